@@ -0,0 +1,291 @@
+package bench
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mini-ninja-64/flotilla/internal/kube"
+	"github.com/mini-ninja-64/flotilla/internal/request"
+	"github.com/mini-ninja-64/flotilla/internal/ui"
+	"github.com/mini-ninja-64/flotilla/internal/util"
+	"github.com/spf13/cobra"
+)
+
+const histogramBuckets = 30
+const refreshInterval = 250 * time.Millisecond
+
+// The request interval has to fit in a time.Duration, and be at least 1ns
+const (
+	minRequestsPerSecond = 1.0 / (24 * 60 * 60)
+	maxRequestsPerSecond = 1e9
+)
+
+type BenchArgs struct {
+	*request.Args
+	RequestsPerSecond float64
+	Duration          time.Duration
+	Requests          uint
+}
+
+// timedRequest sends a single request and reports how long it took, the first
+// request to a pod includes setting up the transport (e.g. a port forward)
+func timedRequest(ctx context.Context, transport request.Transport, podRequest *request.PodRequest, timeout time.Duration) (time.Duration, error) {
+	if podRequest.Err != nil {
+		return 0, podRequest.Err
+	}
+	ctx, cancel := util.WithOptionalTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	response, err := transport.Client(podRequest.Pod).Do(podRequest.Request.Clone(ctx))
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	if _, err := io.Copy(io.Discard, response.Body); err != nil {
+		return 0, err
+	}
+	latency := time.Since(start)
+
+	if response.StatusCode >= 400 {
+		return latency, fmt.Errorf("Unexpected status: %s", response.Status)
+	}
+	return latency, nil
+}
+
+// benchPod sends requests to a single pod at a constant rate, requests are
+// started on schedule regardless of whether earlier requests have completed
+func benchPod(ctx context.Context, transport request.Transport, podRequest *request.PodRequest, benchArgs *BenchArgs, recorder *util.LatencyRecorder) {
+	var wg sync.WaitGroup
+	defer recorder.Stop()
+	defer wg.Wait()
+
+	scheduleDuration := benchArgs.Duration
+	if benchArgs.Requests > 0 {
+		scheduleDuration = 0
+	}
+	scheduleCtx, cancelSchedule := util.WithOptionalTimeout(ctx, scheduleDuration)
+	defer cancelSchedule()
+
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / benchArgs.RequestsPerSecond))
+	defer ticker.Stop()
+
+	for sent := uint(0); benchArgs.Requests == 0 || sent < benchArgs.Requests; sent++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			latency, err := timedRequest(ctx, transport, podRequest, benchArgs.Timeout)
+			recorder.Record(latency, err != nil)
+		}()
+
+		select {
+		case <-ticker.C:
+		case <-scheduleCtx.Done():
+			return
+		}
+	}
+}
+
+func (benchArgs *BenchArgs) progress(summary util.LatencySummary) float64 {
+	if benchArgs.Requests > 0 {
+		return float64(summary.Count) / float64(benchArgs.Requests)
+	}
+	return summary.Elapsed.Seconds() / benchArgs.Duration.Seconds()
+}
+
+func describe(summary util.LatencySummary) string {
+	return fmt.Sprintf(
+		"p50 %s p90 %s p99 %s max %s, %.1f req/s, %.1f%% errors",
+		summary.P50.Round(time.Microsecond*100),
+		summary.P90.Round(time.Microsecond*100),
+		summary.P99.Round(time.Microsecond*100),
+		summary.Max.Round(time.Microsecond*100),
+		summary.Throughput,
+		summary.ErrorRate*100,
+	)
+}
+
+func histogram(recorder *util.LatencyRecorder, summary util.LatencySummary) string {
+	if summary.Count == summary.Errors {
+		return ""
+	}
+	return summary.Min.Round(time.Microsecond*100).String() + " " +
+		ui.Sparkline(recorder.Histogram(histogramBuckets)) + " " +
+		summary.Max.Round(time.Microsecond*100).String()
+}
+
+func report(progressBar *ui.ProgressBar, recorder *util.LatencyRecorder, benchArgs *BenchArgs) {
+	summary := recorder.Summary()
+	progressBar.SetPercentage(benchArgs.progress(summary))
+	progressBar.SetText(describe(summary))
+	progressBar.SetContent(histogram(recorder, summary))
+}
+
+func runBench(ctx context.Context, transport request.Transport, requests []request.PodRequest, benchArgs *BenchArgs) []*util.LatencyRecorder {
+	var wg sync.WaitGroup
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	progressTrackers := ui.NewProgressTrackers(cancel)
+	request.OnTunnelEvent(transport, progressTrackers.LogTunnelEvent)
+	recorders := make([]*util.LatencyRecorder, len(requests))
+	for idx, podRequest := range requests {
+		url := podRequest.Request.URL.String()
+		subtitle := "(" + podRequest.Request.Method + " " + url + " via " + transport.Name() + ")"
+		progressBar := progressTrackers.AddProgressBar(podRequest.Name(), subtitle)
+		recorders[idx] = util.NewLatencyRecorder()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			done := make(chan struct{})
+			go func() {
+				benchPod(ctx, transport, &podRequest, benchArgs, recorders[idx])
+				close(done)
+			}()
+
+			ticker := time.NewTicker(refreshInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					report(progressBar, recorders[idx], benchArgs)
+				case <-done:
+					report(progressBar, recorders[idx], benchArgs)
+					if recorders[idx].Summary().Errors > 0 {
+						progressBar.SetProgressState(ui.Failure)
+					} else {
+						progressBar.SetProgressState(ui.Success)
+					}
+					return
+				}
+			}
+		}()
+	}
+
+	progressTrackers.RunAsync()
+	wg.Wait()
+
+	progressTrackers.Finish()
+	progressTrackers.Wait()
+
+	return recorders
+}
+
+func printSummary(requests []request.PodRequest, recorders []*util.LatencyRecorder) {
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "POD\tREQUESTS\tP50\tP90\tP99\tMAX\tERRORS\tREQ/S")
+	printRow := func(name string, summary util.LatencySummary) {
+		fmt.Fprintf(
+			writer,
+			"%s\t%d\t%s\t%s\t%s\t%s\t%.1f%%\t%.1f\n",
+			name,
+			summary.Count,
+			summary.P50.Round(time.Microsecond*100),
+			summary.P90.Round(time.Microsecond*100),
+			summary.P99.Round(time.Microsecond*100),
+			summary.Max.Round(time.Microsecond*100),
+			summary.ErrorRate*100,
+			summary.Throughput,
+		)
+	}
+	for idx, podRequest := range requests {
+		printRow(podRequest.Name(), recorders[idx].Summary())
+	}
+	printRow("(fleet)", util.MergeLatencies(recorders...))
+	writer.Flush()
+}
+
+// outcomeError reports requests that failed across the fleet, with the same
+// exit code sail uses for failed requests
+func outcomeError(recorders []*util.LatencyRecorder) error {
+	fleet := util.MergeLatencies(recorders...)
+	if fleet.Errors == 0 {
+		return nil
+	}
+	return &util.ExitError{
+		Code: util.ExitRequestFailed,
+		Err:  fmt.Errorf("%d of %d requests failed (%.1f%% errors)", fleet.Errors, fleet.Count, fleet.ErrorRate*100),
+	}
+}
+
+func parseBenchArgs(cmd *cobra.Command, args []string) (*BenchArgs, error) {
+	requestArgs, err := request.ParseArgs(cmd, args)
+	if err != nil {
+		return nil, err
+	}
+	requestsPerSecond, err := cmd.Flags().GetFloat64("rps")
+	if err != nil {
+		return nil, err
+	}
+	if !(requestsPerSecond >= minRequestsPerSecond && requestsPerSecond <= maxRequestsPerSecond) {
+		return nil, fmt.Errorf("--rps must be at least one a day (%g) and at most %g", minRequestsPerSecond, maxRequestsPerSecond)
+	}
+	duration, err := cmd.Flags().GetDuration("duration")
+	if err != nil {
+		return nil, err
+	}
+	requests, err := cmd.Flags().GetUint("requests")
+	if err != nil {
+		return nil, err
+	}
+	if requests == 0 && duration <= 0 {
+		return nil, fmt.Errorf("Either --duration or --requests must be set")
+	}
+
+	return &BenchArgs{
+		Args:              requestArgs,
+		RequestsPerSecond: requestsPerSecond,
+		Duration:          duration,
+		Requests:          requests,
+	}, nil
+}
+
+func Cmd() *cobra.Command {
+	var benchCommand = &cobra.Command{
+		Use:   "bench [service] [path]",
+		Short: "Send a constant rate of HTTP requests to every pod in a service and report latencies",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			benchArgs, err := parseBenchArgs(cmd, args)
+			if err != nil {
+				return err
+			}
+			ctx := cmd.Context()
+
+			kubeClient, err := kube.GetClientUsingFlags(cmd)
+			if err != nil {
+				return err
+			}
+			target, err := request.ResolveTarget(ctx, kubeClient, benchArgs.ServiceName, benchArgs.Port)
+			if err != nil {
+				return err
+			}
+			requests, err := target.Requests(ctx, benchArgs.Args)
+			if err != nil {
+				return err
+			}
+
+			transport, err := request.NewTransport(kubeClient, target.Port, benchArgs.Args)
+			if err != nil {
+				return err
+			}
+			defer transport.Close()
+			recorders := runBench(ctx, transport, requests, benchArgs)
+			printSummary(requests, recorders)
+			return outcomeError(recorders)
+		},
+	}
+
+	request.AddFlags(benchCommand.Flags())
+	benchCommand.Flags().Float64("rps", 10, "The number of requests per second to send to each pod")
+	benchCommand.Flags().Duration("duration", 10*time.Second, "How long to send requests to each pod for")
+	benchCommand.Flags().Uint("requests", 0, "The number of requests to send to each pod, overrides --duration when set")
+
+	return benchCommand
+}
@@ -0,0 +1,377 @@
+package forward
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/mini-ninja-64/flotilla/internal/kube"
+	"github.com/mini-ninja-64/flotilla/internal/request"
+	"github.com/mini-ninja-64/flotilla/internal/ui"
+	"github.com/mini-ninja-64/flotilla/internal/util"
+	"github.com/spf13/cobra"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+type ForwardArgs struct {
+	ServiceName         string
+	Port                uint16
+	BasePort            uint16
+	Address             string
+	ConnectTimeout      time.Duration
+	PortForwardProtocol kube.PortForwardProtocol
+}
+
+// forwardedPod is a pod's local listener, the listener is only open while the
+// pod is Ready and the progress bar is kept when the pod comes and goes
+type forwardedPod struct {
+	pod         *v1.Pod
+	progressBar *ui.ProgressBar
+	listener    net.Listener
+	stop        context.CancelFunc
+	offset      int
+	mu          sync.Mutex
+	active      int
+	total       int
+}
+
+func (forwarded *forwardedPod) report() {
+	forwarded.mu.Lock()
+	defer forwarded.mu.Unlock()
+	if forwarded.listener == nil {
+		return
+	}
+	forwarded.progressBar.SetText(fmt.Sprintf("%s, %d active, %d total", forwarded.listener.Addr(), forwarded.active, forwarded.total))
+}
+
+func (forwarded *forwardedPod) connected(delta int) {
+	forwarded.mu.Lock()
+	forwarded.active += delta
+	if delta > 0 {
+		forwarded.total += delta
+	}
+	forwarded.mu.Unlock()
+	forwarded.report()
+}
+
+type forwarder struct {
+	kubeClient       *kube.KubeClient
+	target           *request.Target
+	forwardArgs      *ForwardArgs
+	tunnels          *kube.TunnelPool
+	progressTrackers *ui.ProgressTrackers
+	mu               sync.Mutex
+	pods             map[types.UID]*forwardedPod
+	offsets          map[int]bool
+}
+
+func (forwarder *forwarder) log(format string, args ...any) {
+	forwarder.progressTrackers.LogChange(time.Now().Format(time.TimeOnly) + " " + fmt.Sprintf(format, args...))
+}
+
+// listen opens the pod's listener on the lowest free port from --base-port,
+// or on a port picked by the OS when no base port was given. Ports already in
+// use by something else are skipped for the rest of the run
+func (forwarder *forwarder) listen() (net.Listener, int, error) {
+	if forwarder.forwardArgs.BasePort == 0 {
+		listener, err := net.Listen("tcp", net.JoinHostPort(forwarder.forwardArgs.Address, "0"))
+		return listener, -1, err
+	}
+	for offset := 0; ; offset++ {
+		if forwarder.offsets[offset] {
+			continue
+		}
+		port := int(forwarder.forwardArgs.BasePort) + offset
+		if port > 65535 {
+			return nil, -1, fmt.Errorf("No ports left after --base-port %d", forwarder.forwardArgs.BasePort)
+		}
+		listener, err := net.Listen("tcp", net.JoinHostPort(forwarder.forwardArgs.Address, strconv.Itoa(port)))
+		if errors.Is(err, syscall.EADDRINUSE) {
+			forwarder.offsets[offset] = true
+			forwarder.log("port %d is already in use, skipping it", port)
+			continue
+		}
+		if err != nil {
+			return nil, -1, err
+		}
+		forwarder.offsets[offset] = true
+		return listener, offset, nil
+	}
+}
+
+// dial opens a connection to the pod, a tunnel that fails to open a stream is
+// evicted and redialed once before giving up
+func (forwarder *forwarder) dial(ctx context.Context, pod *v1.Pod) (net.Conn, error) {
+	var err error
+	for range 2 {
+		connectCtx, cancel := util.WithOptionalTimeout(ctx, forwarder.forwardArgs.ConnectTimeout)
+		var tunnel *kube.PortTunnel
+		tunnel, err = forwarder.tunnels.Get(connectCtx, pod)
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+		var conn net.Conn
+		conn, err = tunnel.Dial()
+		if err == nil {
+			return conn, nil
+		}
+		forwarder.tunnels.Evict(pod, tunnel, err)
+	}
+	return nil, err
+}
+
+func (forwarder *forwarder) handle(ctx context.Context, forwarded *forwardedPod, pod *v1.Pod, conn net.Conn) {
+	defer conn.Close()
+	podConn, err := forwarder.dial(ctx, pod)
+	if err != nil {
+		forwarded.progressBar.SetError(err)
+		forwarder.log("%s connection from %s failed: %s", pod.Name, conn.RemoteAddr(), err)
+		return
+	}
+	defer podConn.Close()
+	forwarded.progressBar.SetProgressState(ui.Success)
+	forwarded.connected(1)
+	util.Splice(conn, conn, podConn)
+	forwarded.connected(-1)
+}
+
+func (forwarder *forwarder) accept(ctx context.Context, forwarded *forwardedPod, pod *v1.Pod, listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go forwarder.handle(ctx, forwarded, pod, conn)
+	}
+}
+
+// connect opens the pod's tunnel up front, so the first connection does not
+// pay for it and the bar shows whether the pod can be reached. The pool
+// reconnects the tunnel if it drops after this
+func (forwarder *forwarder) connect(ctx context.Context, forwarded *forwardedPod, pod *v1.Pod) {
+	connectCtx, cancel := util.WithOptionalTimeout(ctx, forwarder.forwardArgs.ConnectTimeout)
+	defer cancel()
+	if _, err := forwarder.tunnels.Get(connectCtx, pod); err != nil {
+		if ctx.Err() == nil {
+			forwarded.progressBar.SetError(err)
+			forwarder.log("%s tunnel failed: %s", pod.Name, err)
+		}
+		return
+	}
+	forwarded.progressBar.SetProgressState(ui.Success)
+	forwarded.report()
+}
+
+// tunnelEvent shows a tunnel dropping and reconnecting on the pod's bar
+func (forwarder *forwarder) tunnelEvent(event kube.TunnelEvent) {
+	forwarder.progressTrackers.LogTunnelEvent(event)
+	forwarder.mu.Lock()
+	forwarded, seen := forwarder.pods[event.Pod.UID]
+	forwarder.mu.Unlock()
+	if !seen {
+		return
+	}
+	switch event.Type {
+	case kube.TunnelLost:
+		forwarded.progressBar.SetProgressState(ui.Unreachable)
+		forwarded.progressBar.SetText("tunnel lost, reconnecting")
+	case kube.TunnelReconnected:
+		forwarded.progressBar.SetProgressState(ui.Success)
+		forwarded.report()
+	case kube.TunnelGaveUp:
+		forwarded.progressBar.SetError(event.Err)
+	}
+}
+
+func (forwarder *forwarder) start(ctx context.Context, pod *v1.Pod) {
+	forwarder.mu.Lock()
+	defer forwarder.mu.Unlock()
+	forwarded, seen := forwarder.pods[pod.UID]
+	if !seen {
+		forwarded = &forwardedPod{
+			progressBar: forwarder.progressTrackers.AddProgressBar(pod.Name, fmt.Sprintf("(→ port %d)", forwarder.target.Port)),
+			offset:      -1,
+		}
+		forwarder.pods[pod.UID] = forwarded
+	}
+	forwarded.pod = pod
+	if forwarded.listener != nil {
+		return
+	}
+
+	listener, offset, err := forwarder.listen()
+	if err != nil {
+		forwarded.progressBar.SetError(err)
+		forwarder.log("%s could not listen: %s", pod.Name, err)
+		return
+	}
+	podCtx, stop := context.WithCancel(ctx)
+	forwarded.mu.Lock()
+	forwarded.listener, forwarded.offset, forwarded.stop = listener, offset, stop
+	forwarded.mu.Unlock()
+	forwarded.progressBar.SetProgressState(ui.Queued)
+	forwarded.report()
+	forwarder.log("%s listening on %s", pod.Name, listener.Addr())
+
+	go forwarder.accept(podCtx, forwarded, pod, listener)
+	go forwarder.connect(podCtx, forwarded, pod)
+}
+
+// stop closes the pod's listener and frees its port, connections that are
+// already open are left to finish
+func (forwarder *forwarder) stop(pod *v1.Pod, reason string) {
+	forwarder.mu.Lock()
+	defer forwarder.mu.Unlock()
+	forwarded, seen := forwarder.pods[pod.UID]
+	if !seen || forwarded.listener == nil {
+		return
+	}
+	forwarded.mu.Lock()
+	forwarded.stop()
+	forwarded.listener.Close()
+	address := forwarded.listener.Addr()
+	forwarded.listener = nil
+	if forwarded.offset >= 0 {
+		delete(forwarder.offsets, forwarded.offset)
+	}
+	forwarded.mu.Unlock()
+
+	forwarded.progressBar.SetProgressState(ui.Gone)
+	forwarded.progressBar.SetText(reason)
+	forwarder.log("%s %s, closed %s", pod.Name, reason, address)
+}
+
+func (forwarder *forwarder) stopAll() {
+	forwarder.mu.Lock()
+	pods := make([]*v1.Pod, 0, len(forwarder.pods))
+	for _, forwarded := range forwarder.pods {
+		pods = append(pods, forwarded.pod)
+	}
+	forwarder.mu.Unlock()
+	for _, pod := range pods {
+		forwarder.stop(pod, "stopped")
+	}
+}
+
+func (forwarder *forwarder) podEvents(ctx context.Context) kube.PodEvents {
+	return kube.PodEvents{
+		OnReady:   func(pod *v1.Pod) { forwarder.start(ctx, pod) },
+		OnUnready: func(pod *v1.Pod) { forwarder.stop(pod, "not ready") },
+		OnGone: func(pod *v1.Pod) {
+			forwarder.stop(pod, "gone")
+			forwarder.tunnels.Remove(pod)
+		},
+	}
+}
+
+func runForward(ctx context.Context, kubeClient *kube.KubeClient, target *request.Target, forwardArgs *ForwardArgs) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	progressTrackers := ui.NewProgressTrackers(cancel)
+	progressTrackers.RunAsync()
+
+	forwarder := &forwarder{
+		kubeClient:       kubeClient,
+		target:           target,
+		forwardArgs:      forwardArgs,
+		tunnels:          kube.NewTunnelPool(kubeClient, target.Port, forwardArgs.PortForwardProtocol),
+		progressTrackers: progressTrackers,
+		pods:             map[types.UID]*forwardedPod{},
+		offsets:          map[int]bool{},
+	}
+	defer forwarder.tunnels.Close()
+	forwarder.tunnels.OnEvent(forwarder.tunnelEvent)
+
+	err := kube.FollowPodsForService(ctx, kubeClient, target.Service, forwarder.podEvents(ctx))
+	if err == nil {
+		<-ctx.Done()
+	}
+	cancel()
+	forwarder.stopAll()
+
+	progressTrackers.Finish()
+	progressTrackers.Wait()
+	return err
+}
+
+func parseForwardArgs(cmd *cobra.Command, args []string) (*ForwardArgs, error) {
+	port, err := cmd.Flags().GetUint16("port")
+	if err != nil {
+		return nil, err
+	}
+	if port == 0 {
+		return nil, fmt.Errorf("--port must be set")
+	}
+	basePort, err := cmd.Flags().GetUint16("base-port")
+	if err != nil {
+		return nil, err
+	}
+	address, err := cmd.Flags().GetString("address")
+	if err != nil {
+		return nil, err
+	}
+	connectTimeout, err := cmd.Flags().GetDuration("connect-timeout")
+	if err != nil {
+		return nil, err
+	}
+	portForwardProtocolFlag, err := cmd.Flags().GetString("portforward-protocol")
+	if err != nil {
+		return nil, err
+	}
+	portForwardProtocol, err := kube.ParsePortForwardProtocol(portForwardProtocolFlag)
+	if err != nil {
+		return nil, err
+	}
+	return &ForwardArgs{
+		ServiceName:         args[0],
+		Port:                port,
+		BasePort:            basePort,
+		Address:             address,
+		ConnectTimeout:      connectTimeout,
+		PortForwardProtocol: portForwardProtocol,
+	}, nil
+}
+
+func Cmd() *cobra.Command {
+	var forwardCommand = &cobra.Command{
+		Use:   "forward [service]",
+		Short: "Port forward to every ready pod in a service, each on its own local port",
+		Long: "Port forward to every ready pod in a service, each on its own local port.\n\n" +
+			"Listeners are opened and closed as pods become ready and go away, and tunnels that " +
+			"drop are reconnected.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			forwardArgs, err := parseForwardArgs(cmd, args)
+			if err != nil {
+				return err
+			}
+			ctx := cmd.Context()
+
+			kubeClient, err := kube.GetClientUsingFlags(cmd)
+			if err != nil {
+				return err
+			}
+			target, err := request.ResolveServiceTarget(ctx, kubeClient, forwardArgs.ServiceName, forwardArgs.Port)
+			if err != nil {
+				return err
+			}
+			return runForward(ctx, kubeClient, target, forwardArgs)
+		},
+	}
+
+	forwardCommand.Flags().Uint16P("port", "p", 0, "The port to forward to, service ports are translated to the pods' target port")
+	forwardCommand.Flags().Uint16("base-port", 0, "The first local port to listen on, each pod takes the next free one (by default ports are picked by the OS)")
+	forwardCommand.Flags().String("address", "127.0.0.1", "The local address to listen on")
+	forwardCommand.Flags().Duration("connect-timeout", 0, "The maximum time to spend establishing a tunnel to a pod (0 for no timeout)")
+	forwardCommand.Flags().String("portforward-protocol", string(kube.PortForwardAuto), "How port forwards connect to the API server: auto (websocket, falling back to spdy), websocket or spdy")
+
+	return forwardCommand
+}
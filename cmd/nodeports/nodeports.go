@@ -0,0 +1,335 @@
+package nodeports
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/mini-ninja-64/flotilla/internal/kube"
+	"github.com/mini-ninja-64/flotilla/internal/request"
+	"github.com/mini-ninja-64/flotilla/internal/ui"
+	"github.com/mini-ninja-64/flotilla/internal/util"
+	"github.com/spf13/cobra"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type NodePortsArgs struct {
+	*request.Args
+	PortChanged bool
+	HelperPod   string
+	AddressType v1.NodeAddressType
+}
+
+// nodeResult is the outcome of requesting the node port on a single node,
+// expected marks failures that are correct for the service's traffic policy
+type nodeResult struct {
+	node     *v1.Node
+	url      string
+	response *request.PodHttpResponse
+	backend  *v1.Pod
+	problem  string
+	expected bool
+}
+
+// nodePort picks the service port to test, --port can be either the service
+// port or the node port and can be left out when there is only one node port
+func nodePort(service *v1.Service, nodePortsArgs *NodePortsArgs) (int32, error) {
+	nodePorts := []v1.ServicePort{}
+	for _, servicePort := range service.Spec.Ports {
+		if servicePort.NodePort != 0 {
+			nodePorts = append(nodePorts, servicePort)
+		}
+	}
+	if len(nodePorts) == 0 {
+		return 0, fmt.Errorf("Service '%s' is a %s service without node ports", service.Name, service.Spec.Type)
+	}
+	if !nodePortsArgs.PortChanged && len(nodePorts) == 1 {
+		return nodePorts[0].NodePort, nil
+	}
+	for _, servicePort := range nodePorts {
+		if servicePort.Port == int32(nodePortsArgs.Port) || servicePort.NodePort == int32(nodePortsArgs.Port) {
+			return servicePort.NodePort, nil
+		}
+	}
+	return 0, fmt.Errorf("Service '%s' has no node port for port %d", service.Name, nodePortsArgs.Port)
+}
+
+func nodeAddress(node *v1.Node, addressType v1.NodeAddressType) (string, error) {
+	for _, address := range node.Status.Addresses {
+		if address.Type == addressType {
+			return address.Address, nil
+		}
+	}
+	return "", fmt.Errorf("Node '%s' has no %s address", node.Name, addressType)
+}
+
+// backendOf finds which pod answered from the response, it relies on the pod
+// name or IP appearing in the headers or body (e.g. from an echo server or a
+// /hostname endpoint). Longer names are checked first so pod-1 does not match
+// a response from pod-10
+func backendOf(response *request.PodHttpResponse, pods []v1.Pod) *v1.Pod {
+	var text strings.Builder
+	for headerName, headerValues := range response.Response.Header {
+		text.WriteString(headerName + ": " + strings.Join(headerValues, ", ") + "\n")
+	}
+	text.Write(response.Body)
+	haystack := text.String()
+
+	candidates := make([]*v1.Pod, len(pods))
+	for idx := range pods {
+		candidates[idx] = &pods[idx]
+	}
+	slices.SortFunc(candidates, func(a, b *v1.Pod) int {
+		return len(b.Name) - len(a.Name)
+	})
+	for _, pod := range candidates {
+		if strings.Contains(haystack, pod.Name) {
+			return pod
+		}
+	}
+	for _, pod := range candidates {
+		if pod.Status.PodIP != "" && strings.Contains(haystack, pod.Status.PodIP) {
+			return pod
+		}
+	}
+	return nil
+}
+
+func localPods(node *v1.Node, pods []v1.Pod) []string {
+	names := []string{}
+	for _, pod := range pods {
+		if pod.Spec.NodeName == node.Name && kube.IsPodReady(&pod) {
+			names = append(names, pod.Name)
+		}
+	}
+	return names
+}
+
+// check compares where the request was routed with the traffic policy, with
+// Local a node must answer from one of its own pods and a node without any
+// is expected to fail
+func (result *nodeResult) check(service *v1.Service, pods []v1.Pod) {
+	local := service.Spec.ExternalTrafficPolicy == v1.ServiceExternalTrafficPolicyLocal
+	hasLocalPods := len(localPods(result.node, pods)) > 0
+	switch {
+	case result.response.Error != nil:
+		result.problem = result.response.Error.Error()
+		result.expected = local && !hasLocalPods
+	case result.response.Response.StatusCode >= 400:
+		result.problem = result.response.Response.Status
+	case local && !hasLocalPods:
+		result.problem = "answered without any local pods"
+	case local && result.backend != nil && result.backend.Spec.NodeName != result.node.Name:
+		result.problem = fmt.Sprintf("routed to %s on %s despite the Local traffic policy", result.backend.Name, result.backend.Spec.NodeName)
+	}
+}
+
+func (result *nodeResult) report(progressBar *ui.ProgressBar) {
+	backend := ""
+	if result.backend != nil {
+		backend = " from " + result.backend.Name
+	}
+	switch {
+	case result.problem == "":
+		progressBar.SetProgressState(ui.Success)
+		progressBar.SetText(result.response.Response.Status + backend)
+	case result.expected:
+		progressBar.SetProgressState(ui.Queued)
+		progressBar.SetText("no local endpoints")
+	case result.response.Error != nil:
+		progressBar.SetError(result.response.Error)
+	default:
+		progressBar.SetProgressState(ui.Failure)
+		progressBar.SetText(result.problem + backend)
+	}
+	progressBar.SetPercentage(1)
+}
+
+func requestNode(ctx context.Context, transport request.Transport, helper *v1.Pod, url string, nodePortsArgs *NodePortsArgs) *request.PodHttpResponse {
+	ctx, cancel := util.WithOptionalTimeout(ctx, nodePortsArgs.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, nodePortsArgs.Method, url, nil)
+	if err != nil {
+		return &request.PodHttpResponse{Pod: helper, Transport: transport.Name(), Error: err}
+	}
+	for headerName, headerValue := range nodePortsArgs.Headers {
+		req.Header.Add(headerName, headerValue)
+	}
+	return request.Do(ctx, transport, &request.PodRequest{Pod: helper, Request: req})
+}
+
+func runNodePorts(ctx context.Context, transport request.Transport, helper *v1.Pod, service *v1.Service, pods []v1.Pod, nodes []v1.Node, port int32, nodePortsArgs *NodePortsArgs) []*nodeResult {
+	var wg sync.WaitGroup
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	progressTrackers := ui.NewProgressTrackers(cancel)
+	results := make([]*nodeResult, len(nodes))
+	for idx := range nodes {
+		node := &nodes[idx]
+		result := &nodeResult{node: node}
+		results[idx] = result
+		address, err := nodeAddress(node, nodePortsArgs.AddressType)
+		if err != nil {
+			result.response = &request.PodHttpResponse{Pod: helper, Error: err}
+			result.problem = err.Error()
+			progressTrackers.AddProgressBar(node.Name, "").SetError(err)
+			continue
+		}
+		result.url = request.HostURL(address, nodePortsArgs.Protocol, uint16(port), nodePortsArgs.Path)
+		progressBar := progressTrackers.AddProgressBar(node.Name, "("+nodePortsArgs.Method+" "+result.url+" via "+transport.Name()+")")
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result.response = requestNode(ctx, transport, helper, result.url, nodePortsArgs)
+			if result.response.Error == nil {
+				result.backend = backendOf(result.response, pods)
+			}
+			result.check(service, pods)
+			result.report(progressBar)
+		}()
+	}
+
+	progressTrackers.RunAsync()
+	wg.Wait()
+
+	progressTrackers.Finish()
+	progressTrackers.Wait()
+	return results
+}
+
+func printSummary(results []*nodeResult, pods []v1.Pod) {
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "NODE\tURL\tSTATUS\tBACKEND\tLOCAL PODS")
+	for _, result := range results {
+		status := "error"
+		if result.response.Error == nil {
+			status = fmt.Sprintf("%d", result.response.Response.StatusCode)
+		}
+		backend := "unknown"
+		if result.backend != nil {
+			backend = result.backend.Name
+		} else if result.response.Error != nil {
+			backend = "-"
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%d\n", result.node.Name, result.url, status, backend, len(localPods(result.node, pods)))
+	}
+	writer.Flush()
+}
+
+func outcomeError(results []*nodeResult) error {
+	failed := []string{}
+	for _, result := range results {
+		if result.problem != "" && !result.expected {
+			failed = append(failed, fmt.Sprintf("%s (%s)", result.node.Name, result.problem))
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	return &util.ExitError{
+		Code: util.ExitRequestFailed,
+		Err:  fmt.Errorf("%d nodes did not route correctly: %s", len(failed), strings.Join(failed, ", ")),
+	}
+}
+
+// findHelper picks the pod requests are run from outside the cluster, the
+// first ready pod of the service unless one was named
+func findHelper(ctx context.Context, kubeClient *kube.KubeClient, name string, pods []v1.Pod) (*v1.Pod, error) {
+	if name != "" {
+		return kubeClient.Client.CoreV1().Pods(kubeClient.Namespace).Get(ctx, name, metav1.GetOptions{})
+	}
+	for idx := range pods {
+		if kube.IsPodReady(&pods[idx]) {
+			return &pods[idx], nil
+		}
+	}
+	return nil, fmt.Errorf("No ready pods to run requests from, name one with --helper-pod")
+}
+
+func parseNodePortsArgs(cmd *cobra.Command, args []string) (*NodePortsArgs, error) {
+	requestArgs, err := request.ParseArgs(cmd, args)
+	if err != nil {
+		return nil, err
+	}
+	helperPod, err := cmd.Flags().GetString("helper-pod")
+	if err != nil {
+		return nil, err
+	}
+	addressType, err := cmd.Flags().GetString("node-address")
+	if err != nil {
+		return nil, err
+	}
+	return &NodePortsArgs{
+		Args:        requestArgs,
+		PortChanged: cmd.Flags().Changed("port"),
+		HelperPod:   helperPod,
+		AddressType: v1.NodeAddressType(addressType),
+	}, nil
+}
+
+func Cmd() *cobra.Command {
+	var nodePortsCommand = &cobra.Command{
+		Use:   "nodeports [service] [path]",
+		Short: "Send an HTTP request to a service's node port on every node and report which pod answered",
+		Long: "Send an HTTP request to a service's node port on every node and report which pod answered.\n\n" +
+			"Nodes are dialed directly in cluster, outside the cluster requests are run from a helper pod " +
+			"(with --transport exec or ephemeral). The answering pod is found by looking for a pod's name " +
+			"or IP in the response, and with the Local traffic policy it must be on the node that was requested.",
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			nodePortsArgs, err := parseNodePortsArgs(cmd, args)
+			if err != nil {
+				return err
+			}
+			ctx := cmd.Context()
+
+			kubeClient, err := kube.GetClientUsingFlags(cmd)
+			if err != nil {
+				return err
+			}
+			pods, service, err := kube.GetPodsForService(ctx, kubeClient, &nodePortsArgs.ServiceName)
+			if err != nil {
+				return err
+			}
+			port, err := nodePort(service, nodePortsArgs)
+			if err != nil {
+				return err
+			}
+			nodes, err := kubeClient.Client.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+			if err != nil {
+				return err
+			}
+
+			transport, err := request.NewHelperTransport(kubeClient, nodePortsArgs.Args)
+			if err != nil {
+				return err
+			}
+			defer transport.Close()
+			var helper *v1.Pod
+			if transport.Name() != request.TransportDirect {
+				helper, err = findHelper(ctx, kubeClient, nodePortsArgs.HelperPod, pods.Items)
+				if err != nil {
+					return err
+				}
+			}
+
+			results := runNodePorts(ctx, transport, helper, service, pods.Items, nodes.Items, port, nodePortsArgs)
+			printSummary(results, pods.Items)
+			return outcomeError(results)
+		},
+	}
+
+	request.AddFlags(nodePortsCommand.Flags())
+	nodePortsCommand.Flags().String("helper-pod", "", "The pod requests are run from outside the cluster (by default the first ready pod of the service)")
+	nodePortsCommand.Flags().String("node-address", string(v1.NodeInternalIP), "Which node address to request: InternalIP, ExternalIP or Hostname")
+
+	return nodePortsCommand
+}
@@ -0,0 +1,169 @@
+package nodeports
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/mini-ninja-64/flotilla/internal/request"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func nodePortService(policy v1.ServiceExternalTrafficPolicy, ports ...v1.ServicePort) *v1.Service {
+	return &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "web"},
+		Spec:       v1.ServiceSpec{Type: v1.ServiceTypeNodePort, Ports: ports, ExternalTrafficPolicy: policy},
+	}
+}
+
+func TestNodePort(t *testing.T) {
+	web := v1.ServicePort{Name: "http", Port: 80, NodePort: 30080}
+	metrics := v1.ServicePort{Name: "metrics", Port: 9090, NodePort: 30090}
+	internal := v1.ServicePort{Name: "internal", Port: 7000}
+
+	tests := []struct {
+		name    string
+		service *v1.Service
+		port    uint16
+		changed bool
+		want    int32
+	}{
+		{"only node port", nodePortService("", web, internal), 0, false, 30080},
+		{"service port", nodePortService("", web, metrics), 9090, true, 30090},
+		{"node port", nodePortService("", web, metrics), 30080, true, 30080},
+		{"explicit single port", nodePortService("", web), 80, true, 30080},
+	}
+	for _, test := range tests {
+		args := &NodePortsArgs{Args: &request.Args{Port: test.port}, PortChanged: test.changed}
+		port, err := nodePort(test.service, args)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if port != test.want {
+			t.Errorf("%s: expected %d, got %d", test.name, test.want, port)
+		}
+	}
+
+	failures := []struct {
+		name    string
+		service *v1.Service
+		port    uint16
+		changed bool
+	}{
+		{"no node ports", nodePortService("", internal), 0, false},
+		{"ambiguous", nodePortService("", web, metrics), 0, false},
+		{"unknown port", nodePortService("", web, metrics), 8080, true},
+		{"port without node port", nodePortService("", web, internal), 7000, true},
+	}
+	for _, test := range failures {
+		args := &NodePortsArgs{Args: &request.Args{Port: test.port}, PortChanged: test.changed}
+		if _, err := nodePort(test.service, args); err == nil {
+			t.Errorf("%s: expected no node port to be chosen", test.name)
+		}
+	}
+}
+
+func TestNodeAddress(t *testing.T) {
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-a"},
+		Status: v1.NodeStatus{Addresses: []v1.NodeAddress{
+			{Type: v1.NodeHostName, Address: "node-a"},
+			{Type: v1.NodeInternalIP, Address: "192.168.0.10"},
+		}},
+	}
+	if address, err := nodeAddress(node, v1.NodeInternalIP); err != nil || address != "192.168.0.10" {
+		t.Errorf("expected the internal IP, got %q, %v", address, err)
+	}
+	if _, err := nodeAddress(node, v1.NodeExternalIP); err == nil {
+		t.Error("expected a node without an external IP to be rejected")
+	}
+}
+
+func readyPod(name string, node string, ip string) v1.Pod {
+	return v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec:       v1.PodSpec{NodeName: node},
+		Status: v1.PodStatus{
+			Phase:      v1.PodRunning,
+			PodIP:      ip,
+			Conditions: []v1.PodCondition{{Type: v1.PodReady, Status: v1.ConditionTrue}},
+		},
+	}
+}
+
+func nodeResponse(status int, header http.Header, body string) *request.PodHttpResponse {
+	return &request.PodHttpResponse{
+		Response: &http.Response{StatusCode: status, Status: http.StatusText(status), Header: header},
+		Body:     []byte(body),
+	}
+}
+
+func TestBackendOf(t *testing.T) {
+	pods := []v1.Pod{readyPod("pod-1", "node-a", "10.0.0.1"), readyPod("pod-10", "node-b", "10.0.0.10")}
+	tests := []struct {
+		name     string
+		response *request.PodHttpResponse
+		want     string
+	}{
+		{"longer name first", nodeResponse(200, http.Header{}, "hostname: pod-10"), "pod-10"},
+		{"shorter name", nodeResponse(200, http.Header{}, "hostname: pod-1\n"), "pod-1"},
+		{"header", nodeResponse(200, http.Header{"X-Served-By": {"pod-10"}}, "ok"), "pod-10"},
+		{"ip", nodeResponse(200, http.Header{}, "served from 10.0.0.1"), "pod-1"},
+		{"unknown", nodeResponse(200, http.Header{}, "ok"), ""},
+	}
+	for _, test := range tests {
+		backend := backendOf(test.response, pods)
+		name := ""
+		if backend != nil {
+			name = backend.Name
+		}
+		if name != test.want {
+			t.Errorf("%s: expected %q, got %q", test.name, test.want, name)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	nodeA := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-a"}}
+	nodeB := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-b"}}
+	pods := []v1.Pod{readyPod("pod-0", "node-a", "10.0.0.1")}
+	ok := nodeResponse(200, http.Header{}, "pod-0")
+	refused := &request.PodHttpResponse{Error: errors.New("connection refused")}
+
+	tests := []struct {
+		name     string
+		policy   v1.ServiceExternalTrafficPolicy
+		node     *v1.Node
+		response *request.PodHttpResponse
+		problem  bool
+		expected bool
+	}{
+		{"cluster routed elsewhere", v1.ServiceExternalTrafficPolicyCluster, nodeB, ok, false, false},
+		{"cluster refused", v1.ServiceExternalTrafficPolicyCluster, nodeB, refused, true, false},
+		{"cluster error status", v1.ServiceExternalTrafficPolicyCluster, nodeA, nodeResponse(503, http.Header{}, ""), true, false},
+		{"local own pod", v1.ServiceExternalTrafficPolicyLocal, nodeA, ok, false, false},
+		{"local refused without pods", v1.ServiceExternalTrafficPolicyLocal, nodeB, refused, true, true},
+		{"local refused with pods", v1.ServiceExternalTrafficPolicyLocal, nodeA, refused, true, false},
+		{"local answered without pods", v1.ServiceExternalTrafficPolicyLocal, nodeB, ok, true, false},
+	}
+	for _, test := range tests {
+		result := &nodeResult{node: test.node, response: test.response}
+		if test.response.Error == nil {
+			result.backend = backendOf(test.response, pods)
+		}
+		result.check(nodePortService(test.policy), pods)
+		if (result.problem != "") != test.problem || result.expected != test.expected {
+			t.Errorf("%s: expected problem %t and expected %t, got %q and %t", test.name, test.problem, test.expected, result.problem, result.expected)
+		}
+	}
+
+	// With Local a response from another node's pod is a routing problem
+	podElsewhere := append(pods, readyPod("pod-1", "node-b", "10.0.0.2"))
+	result := &nodeResult{node: nodeB, response: ok, backend: &podElsewhere[0]}
+	result.check(nodePortService(v1.ServiceExternalTrafficPolicyLocal), podElsewhere)
+	if result.problem == "" || result.expected {
+		t.Errorf("expected a Local routing problem, got %q, %t", result.problem, result.expected)
+	}
+}
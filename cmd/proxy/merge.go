@@ -0,0 +1,208 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/mini-ninja-64/flotilla/internal/request"
+)
+
+const (
+	mergeFirst     = "first"
+	mergeAllJSON   = "all-json"
+	mergeMultipart = "multipart"
+	mergeMajority  = "majority"
+)
+
+var mergeStrategies = []string{mergeFirst, mergeAllJSON, mergeMultipart, mergeMajority}
+
+const (
+	podHeader          = "X-Flotilla-Pod"
+	podStatusHeader    = "X-Flotilla-Pod-Status"
+	statusHeader       = "X-Flotilla-Status"
+	agreementHeader    = "X-Flotilla-Agreement"
+	errorStatusMessage = "error"
+)
+
+// Headers that only apply to a single connection, they are never copied
+// between the client and the pods
+var hopByHopHeaders = []string{"Connection", "Keep-Alive", "Proxy-Authenticate", "Proxy-Authorization", "Proxy-Connection", "Te", "Trailer", "Transfer-Encoding", "Upgrade"}
+
+func copyHeaders(dst http.Header, src http.Header) {
+	for name, values := range src {
+		name = http.CanonicalHeaderKey(name)
+		if slices.Contains(hopByHopHeaders, name) || name == "Content-Length" {
+			continue
+		}
+		for _, value := range values {
+			dst.Add(name, value)
+		}
+	}
+}
+
+func succeeded(response *request.PodHttpResponse) bool {
+	return response.Error == nil && response.Response.StatusCode < 400
+}
+
+func describeStatus(response *request.PodHttpResponse) string {
+	if response.Error != nil {
+		return errorStatusMessage
+	}
+	return strconv.Itoa(response.Response.StatusCode)
+}
+
+func writeResponse(w http.ResponseWriter, response *request.PodHttpResponse) {
+	w.Header().Set(podHeader, response.Pod.Name)
+	if response.Error != nil {
+		http.Error(w, response.Error.Error(), http.StatusBadGateway)
+		return
+	}
+	copyHeaders(w.Header(), response.Response.Header)
+	w.WriteHeader(response.Response.StatusCode)
+	w.Write(response.Body)
+}
+
+// mergeResponses writes a single response from the responses of every pod,
+// which are in the order they arrived. It returns a summary for the request log
+func mergeResponses(w http.ResponseWriter, strategy string, responses []*request.PodHttpResponse) string {
+	statuses := []string{}
+	for _, response := range responses {
+		w.Header().Add(podStatusHeader, response.Pod.Name+"="+describeStatus(response))
+		statuses = append(statuses, describeStatus(response))
+	}
+	if len(responses) == 0 {
+		http.Error(w, "No ready pods to send the request to", http.StatusServiceUnavailable)
+		return "no ready pods"
+	}
+	summary := strings.Join(statuses, " ")
+
+	switch strategy {
+	case mergeAllJSON:
+		writeAllJSON(w, responses)
+	case mergeMultipart:
+		writeMultipart(w, responses)
+	case mergeMajority:
+		agreement := writeMajority(w, responses)
+		summary += " (" + agreement + ")"
+	default:
+		chosen := responses[0]
+		for _, response := range responses {
+			if succeeded(response) {
+				chosen = response
+				break
+			}
+		}
+		writeResponse(w, chosen)
+		summary += " (" + chosen.Pod.Name + ")"
+	}
+	return summary
+}
+
+type podJSON struct {
+	Pod    string `json:"pod"`
+	Status int    `json:"status,omitempty"`
+	Error  string `json:"error,omitempty"`
+	Body   any    `json:"body,omitempty"`
+}
+
+// writeAllJSON returns every pod's response in one document, bodies that are
+// JSON are embedded as is and anything else as a string
+func writeAllJSON(w http.ResponseWriter, responses []*request.PodHttpResponse) {
+	status := http.StatusOK
+	pods := make([]podJSON, len(responses))
+	for i, response := range responses {
+		pods[i].Pod = response.Pod.Name
+		if !succeeded(response) {
+			status = http.StatusMultiStatus
+		}
+		if response.Error != nil {
+			pods[i].Error = response.Error.Error()
+			continue
+		}
+		pods[i].Status = response.Response.StatusCode
+		if json.Valid(response.Body) {
+			pods[i].Body = json.RawMessage(response.Body)
+		} else if len(response.Body) > 0 {
+			pods[i].Body = string(response.Body)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(pods)
+}
+
+func writeMultipart(w http.ResponseWriter, responses []*request.PodHttpResponse) {
+	parts := multipart.NewWriter(w)
+	w.Header().Set("Content-Type", "multipart/mixed; boundary="+parts.Boundary())
+	w.WriteHeader(http.StatusOK)
+	for _, response := range responses {
+		partHeader := textproto.MIMEHeader{}
+		partHeader.Set(podHeader, response.Pod.Name)
+		partHeader.Set(statusHeader, describeStatus(response))
+		var body []byte
+		if response.Error != nil {
+			partHeader.Set("Content-Type", "text/plain; charset=utf-8")
+			body = []byte(response.Error.Error())
+		} else {
+			if contentType := response.Response.Header.Get("Content-Type"); contentType != "" {
+				partHeader.Set("Content-Type", contentType)
+			}
+			body = response.Body
+		}
+		part, err := parts.CreatePart(partHeader)
+		if err != nil {
+			return
+		}
+		part.Write(body)
+	}
+	parts.Close()
+}
+
+// writeMajority returns the response more than half of the pods agree on,
+// matching on status and body. Without a majority the outcomes are reported
+// as a bad gateway instead
+func writeMajority(w http.ResponseWriter, responses []*request.PodHttpResponse) string {
+	groups := map[string][]*request.PodHttpResponse{}
+	order := []string{}
+	for _, response := range responses {
+		key := errorStatusMessage + ": " + fmt.Sprint(response.Error)
+		if response.Error == nil {
+			key = fmt.Sprintf("%d %x", response.Response.StatusCode, sha256.Sum256(response.Body))
+		}
+		if _, seen := groups[key]; !seen {
+			order = append(order, key)
+		}
+		groups[key] = append(groups[key], response)
+	}
+	largest := order[0]
+	for _, key := range order {
+		if len(groups[key]) > len(groups[largest]) {
+			largest = key
+		}
+	}
+
+	agreement := fmt.Sprintf("%d/%d", len(groups[largest]), len(responses))
+	w.Header().Set(agreementHeader, agreement)
+	if len(groups[largest])*2 > len(responses) {
+		writeResponse(w, groups[largest][0])
+		return agreement + " agree"
+	}
+
+	outcomes := []string{}
+	for _, key := range order {
+		pods := []string{}
+		for _, response := range groups[key] {
+			pods = append(pods, response.Pod.Name)
+		}
+		outcomes = append(outcomes, fmt.Sprintf("%s: %s", describeStatus(groups[key][0]), strings.Join(pods, ", ")))
+	}
+	http.Error(w, "No majority response:\n"+strings.Join(outcomes, "\n"), http.StatusBadGateway)
+	return "no majority"
+}
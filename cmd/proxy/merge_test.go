@@ -0,0 +1,178 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mini-ninja-64/flotilla/internal/request"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func podResponse(pod string, status int, body string) *request.PodHttpResponse {
+	return &request.PodHttpResponse{
+		Pod:      &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: pod}},
+		Response: &http.Response{StatusCode: status, Header: http.Header{"Content-Type": {"text/plain"}}},
+		Body:     []byte(body),
+	}
+}
+
+func podFailure(pod string, err error) *request.PodHttpResponse {
+	return &request.PodHttpResponse{
+		Pod:   &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: pod}},
+		Error: err,
+	}
+}
+
+func TestMergeFirstPrefersSuccess(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	responses := []*request.PodHttpResponse{
+		podFailure("pod-0", errors.New("connection refused")),
+		podResponse("pod-1", http.StatusInternalServerError, "broken"),
+		podResponse("pod-2", http.StatusOK, "ok"),
+	}
+	summary := mergeResponses(recorder, mergeFirst, responses)
+
+	if recorder.Code != http.StatusOK || recorder.Body.String() != "ok" {
+		t.Errorf("expected pod-2's response, got %d %q", recorder.Code, recorder.Body)
+	}
+	if pod := recorder.Header().Get(podHeader); pod != "pod-2" {
+		t.Errorf("expected %s to be pod-2, got %q", podHeader, pod)
+	}
+	if want := "error 500 200 (pod-2)"; summary != want {
+		t.Errorf("expected summary %q, got %q", want, summary)
+	}
+	if statuses := recorder.Header().Values(podStatusHeader); len(statuses) != 3 {
+		t.Errorf("expected a status per pod, got %v", statuses)
+	}
+}
+
+func TestMergeFirstFallsBackToFirstResponse(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	mergeResponses(recorder, mergeFirst, []*request.PodHttpResponse{
+		podResponse("pod-0", http.StatusNotFound, "missing"),
+		podFailure("pod-1", errors.New("connection refused")),
+	})
+	if recorder.Code != http.StatusNotFound || recorder.Header().Get(podHeader) != "pod-0" {
+		t.Errorf("expected pod-0's 404, got %d from %q", recorder.Code, recorder.Header().Get(podHeader))
+	}
+}
+
+func TestMergeWithoutResponses(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	mergeResponses(recorder, mergeFirst, nil)
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected %d, got %d", http.StatusServiceUnavailable, recorder.Code)
+	}
+}
+
+func TestMergeAllJSON(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	mergeResponses(recorder, mergeAllJSON, []*request.PodHttpResponse{
+		podResponse("pod-0", http.StatusOK, `{"ready":true}`),
+		podResponse("pod-1", http.StatusOK, "plain"),
+		podFailure("pod-2", errors.New("connection refused")),
+	})
+	if recorder.Code != http.StatusMultiStatus {
+		t.Errorf("expected %d with a failed pod, got %d", http.StatusMultiStatus, recorder.Code)
+	}
+
+	var pods []map[string]any
+	if err := json.Unmarshal(recorder.Body.Bytes(), &pods); err != nil {
+		t.Fatal(err)
+	}
+	if len(pods) != 3 {
+		t.Fatalf("expected 3 pods, got %d", len(pods))
+	}
+	if body, ok := pods[0]["body"].(map[string]any); !ok || body["ready"] != true {
+		t.Errorf("expected JSON bodies to be embedded, got %v", pods[0]["body"])
+	}
+	if pods[1]["body"] != "plain" {
+		t.Errorf("expected other bodies as strings, got %v", pods[1]["body"])
+	}
+	if pods[2]["error"] != "connection refused" {
+		t.Errorf("expected the pod's error, got %v", pods[2]["error"])
+	}
+}
+
+func TestMergeMultipart(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	mergeResponses(recorder, mergeMultipart, []*request.PodHttpResponse{
+		podResponse("pod-0", http.StatusOK, "zero"),
+		podFailure("pod-1", errors.New("connection refused")),
+	})
+
+	_, params, err := mime.ParseMediaType(recorder.Header().Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader := multipart.NewReader(recorder.Body, params["boundary"])
+	want := []struct{ pod, status, body string }{
+		{"pod-0", "200", "zero"},
+		{"pod-1", errorStatusMessage, "connection refused"},
+	}
+	for _, expected := range want {
+		part, err := reader.NextPart()
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(part)
+		if part.Header.Get(podHeader) != expected.pod || part.Header.Get(statusHeader) != expected.status || string(body) != expected.body {
+			t.Errorf("expected part %v, got %s %s %q", expected, part.Header.Get(podHeader), part.Header.Get(statusHeader), body)
+		}
+	}
+	if _, err := reader.NextPart(); err != io.EOF {
+		t.Errorf("expected only %d parts, got %v", len(want), err)
+	}
+}
+
+func TestMergeMajority(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	summary := mergeResponses(recorder, mergeMajority, []*request.PodHttpResponse{
+		podResponse("pod-0", http.StatusOK, "v1"),
+		podResponse("pod-1", http.StatusOK, "v2"),
+		podResponse("pod-2", http.StatusOK, "v2"),
+	})
+	if recorder.Body.String() != "v2" || recorder.Header().Get(agreementHeader) != "2/3" {
+		t.Errorf("expected v2 with 2/3 agreeing, got %q with %q", recorder.Body, recorder.Header().Get(agreementHeader))
+	}
+	if !strings.HasSuffix(summary, "(2/3 agree)") {
+		t.Errorf("expected the agreement in the summary, got %q", summary)
+	}
+}
+
+func TestMergeMajorityWithoutAgreement(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	summary := mergeResponses(recorder, mergeMajority, []*request.PodHttpResponse{
+		podResponse("pod-0", http.StatusOK, "v1"),
+		podResponse("pod-1", http.StatusOK, "v2"),
+	})
+	if recorder.Code != http.StatusBadGateway {
+		t.Errorf("expected %d without a majority, got %d", http.StatusBadGateway, recorder.Code)
+	}
+	if !strings.Contains(recorder.Body.String(), "pod-0") || !strings.Contains(recorder.Body.String(), "pod-1") {
+		t.Errorf("expected every outcome to be reported, got %q", recorder.Body)
+	}
+	if !strings.HasSuffix(summary, "(no majority)") {
+		t.Errorf("expected no majority in the summary, got %q", summary)
+	}
+}
+
+func TestCopyHeadersSkipsHopByHop(t *testing.T) {
+	dst := http.Header{}
+	copyHeaders(dst, http.Header{
+		"connection":     {"close"},
+		"Content-Length": {"10"},
+		"X-Custom":       {"a", "b"},
+	})
+	if len(dst) != 1 || len(dst.Values("X-Custom")) != 2 {
+		t.Errorf("expected only X-Custom to be copied, got %v", dst)
+	}
+}
@@ -0,0 +1,302 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mini-ninja-64/flotilla/internal/kube"
+	"github.com/mini-ninja-64/flotilla/internal/request"
+	"github.com/mini-ninja-64/flotilla/internal/ui"
+	"github.com/mini-ninja-64/flotilla/internal/util"
+	"github.com/spf13/cobra"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const shutdownTimeout = 5 * time.Second
+
+// errAnswered cancels the requests still in flight once --merge first has a
+// response to return
+var errAnswered = errors.New("another pod answered first")
+
+type ProxyArgs struct {
+	*request.Args
+	Listen string
+	Merge  string
+}
+
+type proxyPod struct {
+	pod         *v1.Pod
+	progressBar *ui.ProgressBar
+	ready       bool
+}
+
+// proxyPods tracks the pods of the service as they come and go, requests are
+// only sent to the ones that are currently Ready
+type proxyPods struct {
+	mu   sync.Mutex
+	pods map[types.UID]*proxyPod
+}
+
+// ready returns copies of the ready pods, the pod events keep updating the
+// tracked pods while requests are in flight
+func (state *proxyPods) ready() []proxyPod {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	ready := []proxyPod{}
+	for _, pod := range state.pods {
+		if pod.ready {
+			ready = append(ready, *pod)
+		}
+	}
+	slices.SortFunc(ready, func(a, b proxyPod) int {
+		return strings.Compare(a.pod.Name, b.pod.Name)
+	})
+	return ready
+}
+
+type proxyHandler struct {
+	target           *request.Target
+	transport        request.Transport
+	pods             *proxyPods
+	proxyArgs        *ProxyArgs
+	progressTrackers *ui.ProgressTrackers
+}
+
+func (handler *proxyHandler) forward(ctx context.Context, incoming *http.Request, body []byte, pod proxyPod) *request.PodHttpResponse {
+	ctx, cancel := util.WithOptionalTimeout(ctx, handler.proxyArgs.Timeout)
+	defer cancel()
+
+	var bodyReader io.Reader
+	if len(body) > 0 {
+		bodyReader = bytes.NewReader(body)
+	}
+	_, host, err := handler.proxyArgs.Addressing(handler.target.Service).First(pod.pod)
+	if err != nil {
+		return &request.PodHttpResponse{Pod: pod.pod, Transport: handler.transport.Name(), Error: err}
+	}
+	url := request.HostURL(host, handler.proxyArgs.Protocol, handler.target.Port, incoming.URL.RequestURI())
+	req, err := http.NewRequestWithContext(ctx, incoming.Method, url, bodyReader)
+	if err != nil {
+		return &request.PodHttpResponse{Pod: pod.pod, Transport: handler.transport.Name(), Error: err}
+	}
+	copyHeaders(req.Header, incoming.Header)
+	for headerName, headerValue := range handler.proxyArgs.Headers {
+		req.Header.Set(headerName, headerValue)
+	}
+
+	pod.progressBar.SetProgressState(ui.Unknown)
+	pod.progressBar.SetText(incoming.Method + " " + incoming.URL.RequestURI())
+	pod.progressBar.SetPercentage(0)
+	response := request.Do(ctx, handler.transport, &request.PodRequest{Pod: pod.pod, Request: req})
+	if errors.Is(context.Cause(ctx), errAnswered) {
+		pod.progressBar.SetProgressState(ui.Queued)
+		pod.progressBar.SetText(incoming.Method + " " + incoming.URL.RequestURI() + ": " + errAnswered.Error())
+	} else if response.Error != nil {
+		pod.progressBar.SetError(response.Error)
+	} else {
+		if succeeded(response) {
+			pod.progressBar.SetProgressState(ui.Success)
+		} else {
+			pod.progressBar.SetProgressState(ui.Failure)
+		}
+		pod.progressBar.SetText(incoming.Method + " " + incoming.URL.RequestURI() + ": " + response.Response.Status)
+	}
+	pod.progressBar.SetPercentage(1)
+	return response
+}
+
+func (handler *proxyHandler) ServeHTTP(w http.ResponseWriter, incoming *http.Request) {
+	body, err := io.ReadAll(incoming.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithCancelCause(incoming.Context())
+	defer cancel(errAnswered)
+	pods := handler.pods.ready()
+	arrived := make(chan *request.PodHttpResponse, len(pods))
+	for _, pod := range pods {
+		go func() {
+			arrived <- handler.forward(ctx, incoming, body, pod)
+		}()
+	}
+	responses := make([]*request.PodHttpResponse, 0, len(pods))
+	for range pods {
+		response := <-arrived
+		responses = append(responses, response)
+		// The first success is all first returns, so a hung pod cannot hold
+		// up the response
+		if handler.proxyArgs.Merge == mergeFirst && succeeded(response) {
+			break
+		}
+	}
+
+	summary := mergeResponses(w, handler.proxyArgs.Merge, responses)
+	handler.progressTrackers.LogChange(fmt.Sprintf("%s %s %s → %s", time.Now().Format(time.TimeOnly), incoming.Method, incoming.URL.RequestURI(), summary))
+}
+
+func (handler *proxyHandler) podAddress(pod *v1.Pod) string {
+	_, host, err := handler.proxyArgs.Addressing(handler.target.Service).First(pod)
+	if err != nil {
+		return err.Error()
+	}
+	return request.HostURL(host, handler.proxyArgs.Protocol, handler.target.Port, "")
+}
+
+func (handler *proxyHandler) podEvents() kube.PodEvents {
+	setReady := func(pod *v1.Pod, ready bool, reason string) {
+		state := handler.pods
+		state.mu.Lock()
+		defer state.mu.Unlock()
+		known, seen := state.pods[pod.UID]
+		if !seen {
+			if !ready {
+				return
+			}
+			known = &proxyPod{
+				pod:         pod,
+				progressBar: handler.progressTrackers.AddProgressBar(pod.Name, "("+handler.podAddress(pod)+" via "+handler.transport.Name()+")"),
+			}
+			state.pods[pod.UID] = known
+		}
+		known.pod = pod
+		known.ready = ready
+		if ready {
+			known.progressBar.SetProgressState(ui.Queued)
+		} else {
+			known.progressBar.SetProgressState(ui.Gone)
+		}
+		known.progressBar.SetText(reason)
+	}
+	return kube.PodEvents{
+		OnReady:   func(pod *v1.Pod) { setReady(pod, true, "ready") },
+		OnUnready: func(pod *v1.Pod) { setReady(pod, false, "not ready") },
+		OnGone: func(pod *v1.Pod) {
+			setReady(pod, false, "gone")
+			handler.transport.Forget(pod)
+		},
+	}
+}
+
+// serve runs the proxy until the context is cancelled
+func serve(ctx context.Context, handler *proxyHandler) error {
+	listener, err := net.Listen("tcp", handler.proxyArgs.Listen)
+	if err != nil {
+		return err
+	}
+	server := &http.Server{Handler: handler}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	handler.progressTrackers.LogChange(fmt.Sprintf("%s listening on http://%s, merging responses with %s", time.Now().Format(time.TimeOnly), listener.Addr(), handler.proxyArgs.Merge))
+	if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func runProxy(ctx context.Context, kubeClient *kube.KubeClient, target *request.Target, transport request.Transport, proxyArgs *ProxyArgs) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	progressTrackers := ui.NewProgressTrackers(cancel)
+	request.OnTunnelEvent(transport, progressTrackers.LogTunnelEvent)
+	progressTrackers.RunAsync()
+
+	handler := &proxyHandler{
+		target:           target,
+		transport:        transport,
+		pods:             &proxyPods{pods: map[types.UID]*proxyPod{}},
+		proxyArgs:        proxyArgs,
+		progressTrackers: progressTrackers,
+	}
+	err := kube.FollowPodsForService(ctx, kubeClient, target.Service, handler.podEvents())
+	if err == nil {
+		err = serve(ctx, handler)
+	}
+	cancel()
+
+	progressTrackers.Finish()
+	progressTrackers.Wait()
+	return err
+}
+
+func parseProxyArgs(cmd *cobra.Command, args []string) (*ProxyArgs, error) {
+	requestArgs, err := request.ParseConnectionArgs(cmd, args[0], "timeout")
+	if err != nil {
+		return nil, err
+	}
+	if err := requestArgs.ErrUnlessSingleFamily("proxy"); err != nil {
+		return nil, err
+	}
+	listen, err := cmd.Flags().GetString("listen")
+	if err != nil {
+		return nil, err
+	}
+	merge, err := cmd.Flags().GetString("merge")
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Contains(mergeStrategies, merge) {
+		return nil, fmt.Errorf("Unknown merge strategy '%s', expected one of %s", merge, strings.Join(mergeStrategies, ", "))
+	}
+	return &ProxyArgs{
+		Args:   requestArgs,
+		Listen: listen,
+		Merge:  merge,
+	}, nil
+}
+
+func Cmd() *cobra.Command {
+	var proxyCommand = &cobra.Command{
+		Use:   "proxy [service]",
+		Short: "Serve HTTP locally, sending every request to all ready pods in a service",
+		Long: "Serve HTTP locally, sending every request to all ready pods in a service.\n\n" +
+			"The pods' responses are merged into one with --merge, and the status from each pod is " +
+			"returned in " + podStatusHeader + " headers.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			proxyArgs, err := parseProxyArgs(cmd, args)
+			if err != nil {
+				return err
+			}
+			ctx := cmd.Context()
+
+			kubeClient, err := kube.GetClientUsingFlags(cmd)
+			if err != nil {
+				return err
+			}
+			target, err := request.ResolveServiceTarget(ctx, kubeClient, proxyArgs.ServiceName, proxyArgs.Port)
+			if err != nil {
+				return err
+			}
+			transport, err := request.NewTransport(kubeClient, target.Port, proxyArgs.Args)
+			if err != nil {
+				return err
+			}
+			defer transport.Close()
+			return runProxy(ctx, kubeClient, target, transport, proxyArgs)
+		},
+	}
+
+	request.AddConnectionFlags(proxyCommand.Flags(), "timeout")
+	proxyCommand.Flags().String("listen", "127.0.0.1:9000", "The address to serve the proxy on, anyone who can reach it can send requests to the pods with your credentials")
+	proxyCommand.Flags().String("merge", mergeFirst, "How pod responses are combined: first (the first success to arrive, the other pods are not waited for), all-json (every response in a JSON array), multipart (every response as a multipart/mixed part) or majority (the response most pods agree on, or 502 without a majority)")
+
+	return proxyCommand
+}
@@ -0,0 +1,319 @@
+package rolloutcheck
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mini-ninja-64/flotilla/internal/kube"
+	"github.com/mini-ninja-64/flotilla/internal/request"
+	"github.com/mini-ninja-64/flotilla/internal/ui"
+	"github.com/mini-ninja-64/flotilla/internal/util"
+	"github.com/spf13/cobra"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+type RolloutCheckArgs struct {
+	*request.Args
+	Expectations   []*util.Expectation
+	OverallTimeout time.Duration
+	Interval       time.Duration
+}
+
+// podStatus is the last known state of a pod, generation changes whenever the
+// pod's readiness is reset so a check started before then can be discarded
+type podStatus struct {
+	podRequest  *request.PodRequest
+	progressBar *ui.ProgressBar
+	ready       bool
+	matched     bool
+	detail      string
+	generation  uint64
+}
+
+// podCheck is a check of a pod as it was when the check was started
+type podCheck struct {
+	status     *podStatus
+	generation uint64
+}
+
+// rollout tracks every pod of the service that has been seen, only pods that
+// are currently Ready count towards the rollout being complete
+type rollout struct {
+	mu   sync.Mutex
+	pods map[types.UID]*podStatus
+}
+
+func (state *rollout) pending() []podCheck {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	pending := []podCheck{}
+	for _, status := range state.pods {
+		if status.ready && !status.matched {
+			pending = append(pending, podCheck{status: status, generation: status.generation})
+		}
+	}
+	return pending
+}
+
+func (state *rollout) complete() bool {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	readyCount := 0
+	for _, status := range state.pods {
+		if !status.ready {
+			continue
+		}
+		if !status.matched {
+			return false
+		}
+		readyCount++
+	}
+	return readyCount > 0
+}
+
+func (state *rollout) laggards() []string {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	laggards := []string{}
+	for _, status := range state.pods {
+		if status.ready && !status.matched {
+			laggards = append(laggards, fmt.Sprintf("%s (%s)", status.podRequest.Pod.Name, status.detail))
+		}
+	}
+	slices.Sort(laggards)
+	return laggards
+}
+
+// evaluate decides whether a response matches every expectation, returning a
+// description of the mismatch when it does not
+func evaluate(response *request.PodHttpResponse, expectations []*util.Expectation) (bool, string) {
+	if response.Error != nil {
+		return false, response.Error.Error()
+	}
+	if response.Response.StatusCode < 200 || response.Response.StatusCode >= 300 {
+		return false, response.Response.Status
+	}
+	actuals := []string{}
+	for _, expectation := range expectations {
+		actual, ok, err := expectation.Check(response.Body)
+		if err != nil {
+			return false, err.Error()
+		}
+		if !ok {
+			return false, fmt.Sprintf("%s is '%s', expected '%s'", expectation.Expression, actual, expectation.Expected)
+		}
+		actuals = append(actuals, expectation.Expression+"="+actual)
+	}
+	if len(actuals) == 0 {
+		return true, response.Response.Status
+	}
+	return true, strings.Join(actuals, ", ")
+}
+
+func checkPod(ctx context.Context, transport request.Transport, podRequest *request.PodRequest, rolloutArgs *RolloutCheckArgs) (bool, string) {
+	requestCtx, cancel := util.WithOptionalTimeout(ctx, rolloutArgs.Timeout)
+	defer cancel()
+	response := request.Do(requestCtx, transport, podRequest)
+	return evaluate(response, rolloutArgs.Expectations)
+}
+
+func (status *podStatus) show(matched bool, detail string) {
+	status.progressBar.SetText(detail)
+	status.progressBar.SetPercentage(1)
+	if matched {
+		status.progressBar.SetProgressState(ui.Success)
+	} else {
+		status.progressBar.SetProgressState(ui.Failure)
+	}
+}
+
+func runRolloutCheck(ctx context.Context, kubeClient *kube.KubeClient, target *request.Target, transport request.Transport, rolloutArgs *RolloutCheckArgs) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	state := &rollout{pods: map[types.UID]*podStatus{}}
+	progressTrackers := ui.NewProgressTrackers(cancel)
+	request.OnTunnelEvent(transport, progressTrackers.LogTunnelEvent)
+	progressTrackers.RunAsync()
+
+	setReady := func(pod *v1.Pod, ready bool) {
+		state.mu.Lock()
+		defer state.mu.Unlock()
+		status, seen := state.pods[pod.UID]
+		if !seen {
+			if !ready {
+				return
+			}
+			podRequest, err := target.Request(ctx, rolloutArgs.Args, pod)
+			if err != nil {
+				return
+			}
+			status = &podStatus{
+				podRequest:  podRequest,
+				progressBar: progressTrackers.AddProgressBar(pod.Name, "("+podRequest.Request.URL.String()+" via "+transport.Name()+")"),
+			}
+			state.pods[pod.UID] = status
+		}
+		status.generation++
+		status.ready = ready
+		status.matched = false
+		status.detail = "not checked yet"
+		if ready {
+			status.progressBar.SetProgressState(ui.Queued)
+			status.progressBar.SetText("waiting")
+		} else {
+			status.progressBar.SetProgressState(ui.Gone)
+			status.progressBar.SetText("not ready")
+		}
+	}
+	events := kube.PodEvents{
+		OnReady:   func(pod *v1.Pod) { setReady(pod, true) },
+		OnUnready: func(pod *v1.Pod) { setReady(pod, false) },
+		OnGone: func(pod *v1.Pod) {
+			state.mu.Lock()
+			defer state.mu.Unlock()
+			if status, seen := state.pods[pod.UID]; seen {
+				status.generation++
+				status.progressBar.SetProgressState(ui.Gone)
+				status.progressBar.SetText("gone")
+				delete(state.pods, pod.UID)
+			}
+		},
+	}
+
+	err := kube.FollowPodsForService(ctx, kubeClient, target.Service, events)
+	complete := false
+	for err == nil && ctx.Err() == nil {
+		var wg sync.WaitGroup
+		for _, check := range state.pending() {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				status := check.status
+				matched, detail := checkPod(ctx, transport, status.podRequest, rolloutArgs)
+				// Keep the last real result when giving up part way through a check
+				if ctx.Err() != nil {
+					return
+				}
+				state.mu.Lock()
+				defer state.mu.Unlock()
+				// The pod became unready, ready again or went away while it
+				// was being checked, so the result no longer applies
+				if status.generation != check.generation {
+					return
+				}
+				status.show(matched, detail)
+				status.matched = matched
+				status.detail = detail
+				if matched {
+					progressTrackers.LogChange(fmt.Sprintf("%s %s now matches", time.Now().Format(time.TimeOnly), status.podRequest.Pod.Name))
+				}
+			}()
+		}
+		wg.Wait()
+
+		if complete = state.complete(); complete {
+			break
+		}
+		select {
+		case <-time.After(rolloutArgs.Interval):
+		case <-ctx.Done():
+		}
+	}
+	laggards := state.laggards()
+
+	progressTrackers.Finish()
+	progressTrackers.Wait()
+
+	if err != nil {
+		return err
+	}
+	if !complete {
+		if len(laggards) == 0 {
+			return fmt.Errorf("No ready pods found for service '%s'", target.Service.Name)
+		}
+		return fmt.Errorf("%d pods did not match:\n  %s", len(laggards), strings.Join(laggards, "\n  "))
+	}
+	fmt.Println("All ready pods match")
+	return nil
+}
+
+func parseRolloutCheckArgs(cmd *cobra.Command, args []string) (*RolloutCheckArgs, error) {
+	requestArgs, err := request.ParseArgsWithTimeout(cmd, args, "request-timeout")
+	if err != nil {
+		return nil, err
+	}
+	if err := requestArgs.ErrUnlessSingleFamily("rollout-check"); err != nil {
+		return nil, err
+	}
+	expectationFlags, err := cmd.Flags().GetStringArray("expect-jsonpath")
+	if err != nil {
+		return nil, err
+	}
+	expectations := []*util.Expectation{}
+	for _, expectationFlag := range expectationFlags {
+		expectation, err := util.ParseExpectation(expectationFlag)
+		if err != nil {
+			return nil, err
+		}
+		expectations = append(expectations, expectation)
+	}
+	overallTimeout, err := cmd.Flags().GetDuration("timeout")
+	if err != nil {
+		return nil, err
+	}
+	interval, err := cmd.Flags().GetDuration("interval")
+	if err != nil {
+		return nil, err
+	}
+
+	return &RolloutCheckArgs{
+		Args:           requestArgs,
+		Expectations:   expectations,
+		OverallTimeout: overallTimeout,
+		Interval:       interval,
+	}, nil
+}
+
+func Cmd() *cobra.Command {
+	var rolloutCheckCommand = &cobra.Command{
+		Use:   "rollout-check [service] [path]",
+		Short: "Wait until every ready pod in a service returns the expected response",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			rolloutArgs, err := parseRolloutCheckArgs(cmd, args)
+			if err != nil {
+				return err
+			}
+			ctx, cancel := util.WithOptionalTimeout(cmd.Context(), rolloutArgs.OverallTimeout)
+			defer cancel()
+
+			kubeClient, err := kube.GetClientUsingFlags(cmd)
+			if err != nil {
+				return err
+			}
+			target, err := request.ResolveServiceTarget(ctx, kubeClient, rolloutArgs.ServiceName, rolloutArgs.Port)
+			if err != nil {
+				return err
+			}
+			transport, err := request.NewTransport(kubeClient, target.Port, rolloutArgs.Args)
+			if err != nil {
+				return err
+			}
+			defer transport.Close()
+			return runRolloutCheck(ctx, kubeClient, target, transport, rolloutArgs)
+		},
+	}
+
+	request.AddFlagsWithTimeout(rolloutCheckCommand.Flags(), "request-timeout")
+	rolloutCheckCommand.Flags().StringArray("expect-jsonpath", []string{}, "A JSONPath expression and the value it must produce in the form expression=value (e.g. '.version=1.4.2'), can be repeated")
+	rolloutCheckCommand.Flags().Duration("timeout", 10*time.Minute, "How long to wait for every pod to match before giving up (0 for no timeout)")
+	rolloutCheckCommand.Flags().Duration("interval", 5*time.Second, "How long to wait between checks of pods that do not match yet")
+
+	return rolloutCheckCommand
+}
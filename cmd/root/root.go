@@ -1,7 +1,14 @@
 package root
 
 import (
+	"github.com/mini-ninja-64/flotilla/cmd/bench"
+	"github.com/mini-ninja-64/flotilla/cmd/forward"
+	"github.com/mini-ninja-64/flotilla/cmd/nodeports"
+	"github.com/mini-ninja-64/flotilla/cmd/proxy"
+	"github.com/mini-ninja-64/flotilla/cmd/rolloutcheck"
 	"github.com/mini-ninja-64/flotilla/cmd/sail"
+	"github.com/mini-ninja-64/flotilla/cmd/scenario"
+	"github.com/mini-ninja-64/flotilla/cmd/tunnel"
 	"github.com/spf13/cobra"
 )
 
@@ -11,6 +18,13 @@ func Cmd() *cobra.Command {
 		Short: "Flotilla lets you make multiple requests to all kubernetes pods in a service",
 	}
 	rootCommand.AddCommand(sail.Cmd())
+	rootCommand.AddCommand(bench.Cmd())
+	rootCommand.AddCommand(rolloutcheck.Cmd())
+	rootCommand.AddCommand(scenario.Cmd())
+	rootCommand.AddCommand(proxy.Cmd())
+	rootCommand.AddCommand(tunnel.Cmd())
+	rootCommand.AddCommand(forward.Cmd())
+	rootCommand.AddCommand(nodeports.Cmd())
 	rootCommand.PersistentFlags().String("kubeconfig", "", "The kubeconfig file to use")
 	rootCommand.PersistentFlags().String("context", "", "The context to use")
 	rootCommand.PersistentFlags().StringP("namespace", "n", "", "The namespace to use")
@@ -0,0 +1,185 @@
+package sail
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mini-ninja-64/flotilla/internal/kube"
+	"github.com/mini-ninja-64/flotilla/internal/request"
+	"github.com/mini-ninja-64/flotilla/internal/ui"
+	"github.com/mini-ninja-64/flotilla/internal/util"
+)
+
+const healthCheckInterval = 2 * time.Second
+
+// waveSize caps the batch size at the number of disruptions the strictest
+// PodDisruptionBudget covering the pods currently allows. A budget allowing
+// none (as is usual straight after a wave, while pods restart) is polled until
+// it recovers, for up to the timeout
+func waveSize(ctx context.Context, kubeClient *kube.KubeClient, target *request.Target, batchSize int, timeout time.Duration, onWait func(string)) (int, string, error) {
+	waitCtx, cancel := util.WithOptionalTimeout(ctx, timeout)
+	defer cancel()
+	waiting := false
+	for {
+		budget, err := kube.GetDisruptionBudget(ctx, kubeClient, target.Pods.Items)
+		if err != nil {
+			return 0, "", fmt.Errorf("Unable to check PodDisruptionBudgets: %w", err)
+		}
+		if budget == nil {
+			return batchSize, "", nil
+		}
+		allowed := int(budget.Status.DisruptionsAllowed)
+		if allowed > 0 && allowed < batchSize {
+			return allowed, fmt.Sprintf(" (limited by PodDisruptionBudget '%s')", budget.Name), nil
+		}
+		if allowed > 0 {
+			return batchSize, "", nil
+		}
+
+		if !waiting {
+			onWait(fmt.Sprintf("waiting for PodDisruptionBudget '%s' to allow disruptions", budget.Name))
+			waiting = true
+		}
+		select {
+		case <-time.After(healthCheckInterval):
+		case <-waitCtx.Done():
+			if ctx.Err() != nil {
+				return 0, "", ctx.Err()
+			}
+			return 0, "", fmt.Errorf("PodDisruptionBudget '%s' allowed no disruptions for %s", budget.Name, timeout)
+		}
+	}
+}
+
+func checkHealth(ctx context.Context, transport request.Transport, healthRequest *request.PodRequest, timeout time.Duration) error {
+	requestCtx, cancel := util.WithOptionalTimeout(ctx, timeout)
+	defer cancel()
+	response := request.Do(requestCtx, transport, healthRequest)
+	if response.Error != nil {
+		return response.Error
+	}
+	if response.Response.StatusCode < 200 || response.Response.StatusCode >= 300 {
+		return fmt.Errorf("%s", response.Response.Status)
+	}
+	return nil
+}
+
+// healthGate polls the health path on every pod processed so far until they
+// all pass, or gives up after the health timeout
+func healthGate(ctx context.Context, transport request.Transport, target *request.Target, requests []request.PodRequest, progressBars []*ui.ProgressBar, sailArgs *SailArgs) error {
+	healthArgs := *sailArgs.Args
+	healthArgs.Method = http.MethodGet
+	healthArgs.Path = sailArgs.HealthPath
+
+	gateCtx, cancel := util.WithOptionalTimeout(ctx, sailArgs.HealthTimeout)
+	defer cancel()
+
+	pending := make([]int, len(requests))
+	for idx := range requests {
+		pending[idx] = idx
+	}
+	for {
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		unhealthy := []int{}
+		reasons := []string{}
+		for _, idx := range pending {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				podRequest := requests[idx]
+				healthRequest, err := request.NewPodRequest(gateCtx, podRequest.Pod, podRequest.IP, podRequest.Request.URL.Hostname(), healthArgs.Method, healthArgs.Protocol, target.Port, healthArgs.Headers, healthArgs.Path)
+				if err == nil {
+					err = checkHealth(gateCtx, transport, healthRequest, sailArgs.Timeout)
+				}
+				if err == nil {
+					return
+				}
+				mu.Lock()
+				defer mu.Unlock()
+				unhealthy = append(unhealthy, idx)
+				reasons = append(reasons, fmt.Sprintf("%s (%s)", podRequest.Name(), err))
+			}()
+		}
+		wg.Wait()
+
+		if len(unhealthy) == 0 {
+			return nil
+		}
+		select {
+		case <-time.After(healthCheckInterval):
+			pending = unhealthy
+		case <-gateCtx.Done():
+			for _, idx := range unhealthy {
+				progressBars[idx].SetProgressState(ui.Failure)
+				progressBars[idx].SetText("unhealthy")
+			}
+			return fmt.Errorf("Pods did not become healthy: %s", strings.Join(reasons, ", "))
+		}
+	}
+}
+
+// batchedWithClient sends requests in waves rather than all at once, each
+// wave must succeed (and pass the health gate, if there is one) before the
+// next starts and the first failure aborts the remaining waves
+func batchedWithClient(ctx context.Context, kubeClient *kube.KubeClient, target *request.Target, transport request.Transport, requests []request.PodRequest, throttle *util.Throttle, output *request.OutputWriter, sailArgs *SailArgs) ([]*request.PodHttpResponse, error) {
+	batchSize, err := util.ParseBatchSize(sailArgs.BatchSize, len(requests))
+	if err != nil {
+		return nil, err
+	}
+	responses := make([]*request.PodHttpResponse, len(requests))
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	progressTrackers := ui.NewProgressTrackers(cancel)
+	progressBars := addProgressBars(progressTrackers, requests, transport)
+	progressTrackers.RunAsync()
+	for _, progressBar := range progressBars {
+		progressBar.SetProgressState(ui.Queued)
+		progressBar.SetText("waiting for an earlier wave")
+	}
+
+	var waveErr error
+	processed := 0
+	for wave := 1; processed < len(requests) && waveErr == nil; wave++ {
+		size, limitedBy, err := waveSize(ctx, kubeClient, target, batchSize, sailArgs.HealthTimeout, func(reason string) {
+			progressTrackers.LogChange(fmt.Sprintf("%s wave %d: %s", time.Now().Format(time.TimeOnly), wave, reason))
+		})
+		if err != nil {
+			waveErr = err
+			break
+		}
+		end := min(processed+size, len(requests))
+		progressTrackers.LogChange(fmt.Sprintf("%s wave %d: %d pods%s", time.Now().Format(time.TimeOnly), wave, end-processed, limitedBy))
+
+		waveResponses := fanOut(ctx, transport, requests[processed:end], progressBars[processed:end], throttle, output, sailArgs.RetryPolicy, sailArgs.Timeout)
+		copy(responses[processed:end], waveResponses)
+		processed = end
+		if err := outcomeError(waveResponses); err != nil {
+			waveErr = fmt.Errorf("Wave %d failed: %w", wave, err)
+			break
+		}
+
+		if sailArgs.HealthPath != "" {
+			if err := healthGate(ctx, transport, target, requests[:processed], progressBars[:processed], sailArgs); err != nil {
+				waveErr = fmt.Errorf("Health gate after wave %d failed: %w", wave, err)
+				break
+			}
+			progressTrackers.LogChange(fmt.Sprintf("%s wave %d: health gate passed", time.Now().Format(time.TimeOnly), wave))
+		}
+	}
+
+	for _, progressBar := range progressBars[processed:] {
+		progressBar.SetProgressState(ui.Cancelled)
+		progressBar.SetText("skipped")
+	}
+
+	progressTrackers.Finish()
+	progressTrackers.Wait()
+	return responses, waveErr
+}
@@ -0,0 +1,133 @@
+package sail
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/mini-ninja-64/flotilla/internal/kube"
+	"github.com/mini-ninja-64/flotilla/internal/request"
+	"github.com/mini-ninja-64/flotilla/internal/ui"
+	"github.com/mini-ninja-64/flotilla/internal/util"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+var errPodGone = errors.New("pod is gone")
+
+// followedPod has a tracker per request, one per address family when both are
+// requested
+type followedPod struct {
+	progressBars []*ui.ProgressBar
+	cancel       context.CancelCauseFunc
+}
+
+func markGone(progressBar *ui.ProgressBar) {
+	progressBar.SetProgressState(ui.Gone)
+	progressBar.SetText("gone")
+}
+
+// followPod requests a single pod once, or every interval when watching,
+// until the pod is gone or the context is cancelled
+func followPod(ctx context.Context, transport request.Transport, podRequest *request.PodRequest, progressTrackers *ui.ProgressTrackers, progressBar *ui.ProgressBar, sailArgs *SailArgs, throttle *util.Throttle, output *request.OutputWriter) {
+	history := podHistory{podName: podRequest.Name()}
+	for {
+		response := throttledRequest(ctx, transport, podRequest, progressBar, throttle, sailArgs.RetryPolicy, sailArgs.Timeout)
+		// Requests cut short by the pod going away or by shutting down are
+		// not recorded
+		if ctx.Err() != nil {
+			return
+		}
+		output.Write(podRequest, response)
+		if sailArgs.Watch <= 0 {
+			return
+		}
+		history.record(progressTrackers, progressBar, response)
+
+		select {
+		case <-time.After(sailArgs.Watch):
+		case <-ctx.Done():
+			return
+		}
+		progressBar.SetPercentage(0)
+	}
+}
+
+// followWithClient keeps a pod informer running for the service, every pod
+// that becomes Ready gets a tracker and a request, and pods that are deleted
+// are marked as gone
+func followWithClient(ctx context.Context, kubeClient *kube.KubeClient, target *request.Target, transport request.Transport, throttle *util.Throttle, output *request.OutputWriter, sailArgs *SailArgs) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	stopped := false
+	followedPods := map[types.UID]followedPod{}
+
+	progressTrackers := ui.NewProgressTrackers(cancel)
+	request.OnTunnelEvent(transport, progressTrackers.LogTunnelEvent)
+	progressTrackers.RunAsync()
+
+	events := kube.PodEvents{
+		OnReady: func(pod *v1.Pod) {
+			podRequests, err := target.PodRequests(ctx, sailArgs.Args, pod)
+			if err != nil {
+				progressTrackers.AddProgressBar(pod.Name, "").SetError(err)
+				return
+			}
+
+			// Handlers can still fire while we are shutting down
+			mu.Lock()
+			defer mu.Unlock()
+			if _, followed := followedPods[pod.UID]; stopped || followed {
+				return
+			}
+			podCtx, cancelPod := context.WithCancelCause(ctx)
+			followed := followedPod{cancel: cancelPod}
+			for _, podRequest := range podRequests {
+				progressBar := addProgressBar(progressTrackers, &podRequest, transport)
+				followed.progressBars = append(followed.progressBars, progressBar)
+
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					followPod(podCtx, transport, &podRequest, progressTrackers, progressBar, sailArgs, throttle, output)
+					if errors.Is(context.Cause(podCtx), errPodGone) {
+						markGone(progressBar)
+					}
+				}()
+			}
+			followedPods[pod.UID] = followed
+		},
+		OnGone: func(pod *v1.Pod) {
+			mu.Lock()
+			followed, ok := followedPods[pod.UID]
+			delete(followedPods, pod.UID)
+			mu.Unlock()
+			if !ok {
+				return
+			}
+			for _, progressBar := range followed.progressBars {
+				markGone(progressBar)
+			}
+			followed.cancel(errPodGone)
+			transport.Forget(pod)
+		},
+	}
+
+	err := kube.FollowPodsForService(ctx, kubeClient, target.Service, events)
+	if err == nil {
+		<-ctx.Done()
+	}
+	cancel()
+	mu.Lock()
+	stopped = true
+	mu.Unlock()
+	wg.Wait()
+
+	progressTrackers.Finish()
+	progressTrackers.Wait()
+	return err
+}
@@ -2,32 +2,23 @@ package sail
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
-	"net"
 	"net/http"
+	"os"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/mini-ninja-64/flotilla/internal/kube"
+	"github.com/mini-ninja-64/flotilla/internal/request"
 	"github.com/mini-ninja-64/flotilla/internal/ui"
 	"github.com/mini-ninja-64/flotilla/internal/util"
 	"github.com/spf13/cobra"
-	v1 "k8s.io/api/core/v1"
 )
 
 //TODO: write tests
-//TODO: Add JSON/YAML output options
-
-type PodHttpResponse struct {
-	Response *http.Response
-	Error    error
-	Pod      *v1.Pod
-	Body     []byte
-}
-
-// TODO: Maybe a `Closable“ interface is more go-ish 🤔
-type ClientCloser = func()
-type ClientFactory = func(*PodRequest) (*http.Client, ClientCloser, error)
 
 type LengthWriter struct {
 	currentLength uint64
@@ -50,72 +41,173 @@ func NewLengthWriter(writeCallback func(increase uint64, currentLength uint64))
 	}
 }
 
-func requestWithClient(clientFactory ClientFactory, request *PodRequest) (*http.Response, error) {
-	httpClient, closer, err := clientFactory(request)
+func requestWithClient(ctx context.Context, transport request.Transport, podRequest *request.PodRequest, progressBar *ui.ProgressBar) *request.PodHttpResponse {
+	result := &request.PodHttpResponse{
+		Pod:       podRequest.Pod,
+		Transport: transport.Name(),
+	}
+
+	response, err := transport.Client(podRequest.Pod).Do(podRequest.Request.WithContext(ctx))
 	if err != nil {
-		return nil, err
+		result.Error = err
+		return result
+	}
+	defer response.Body.Close()
+	result.Response = response
+
+	progressBar.SetText(response.Status)
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		progressBar.SetProgressState(ui.Success)
+	} else if response.StatusCode >= 400 {
+		progressBar.SetProgressState(ui.Failure)
 	}
-	if closer != nil {
-		defer closer()
+
+	contentLength := float64(response.ContentLength)
+	if contentLength < 0 {
+		// println("unknown content length")
+		// TODO: Handle with spinner
 	}
 
-	return httpClient.Do(request.Request)
+	bodyBuffer := NewLengthWriter(func(uint64, currentLength uint64) {
+		progressBar.SetPercentage(float64(currentLength) / contentLength)
+	})
+
+	teeReader := io.TeeReader(response.Body, bodyBuffer)
+	result.Body, result.Error = io.ReadAll(teeReader)
+	progressBar.SetContent(string(result.Body))
+	return result
 }
 
-func requestsWithClient(clientFactory ClientFactory, requests []PodRequest) []*PodHttpResponse {
-	var wgReq sync.WaitGroup
-	requestCount := len(requests)
-	responses := make([]*PodHttpResponse, requestCount)
+func requestWithRetries(ctx context.Context, transport request.Transport, podRequest *request.PodRequest, progressBar *ui.ProgressBar, retryPolicy *util.RetryPolicy, timeout time.Duration) *request.PodHttpResponse {
+	var result *request.PodHttpResponse
+	attempts := []request.Attempt{}
+	for attempt := uint(1); ; attempt++ {
+		progressBar.SetAttempt(attempt, retryPolicy.MaxAttempts())
+
+		attemptCtx, cancelAttempt := util.WithOptionalTimeout(ctx, timeout)
+		start := time.Now()
+		result = requestWithClient(attemptCtx, transport, podRequest, progressBar)
+		// Only the attempt's own --timeout counts as timing out, a connect
+		// timeout is a connect error that can be retried
+		timedOut := ctx.Err() == nil && errors.Is(attemptCtx.Err(), context.DeadlineExceeded)
+		cancelAttempt()
+
+		statusCode := 0
+		retryAfter := ""
+		if result.Response != nil {
+			statusCode = result.Response.StatusCode
+			retryAfter = result.Response.Header.Get("Retry-After")
+		}
+		attempts = append(attempts, request.Attempt{
+			Number:     attempt,
+			StatusCode: statusCode,
+			Error:      result.Error,
+			StartedAt:  start,
+			Duration:   time.Since(start),
+		})
+		if result.Error != nil {
+			progressBar.SetError(result.Error)
+		}
+
+		// Cancellations and timeouts are never worth retrying, connect errors
+		// are anything else that stopped us getting a response
+		if ctx.Err() != nil || timedOut {
+			break
+		}
+		if !retryPolicy.ShouldRetry(attempt, statusCode) {
+			break
+		}
+
+		delay := retryPolicy.Delay(attempt, retryAfter)
+		progressBar.SetText(fmt.Sprintf("%s, retrying in %s", describeAttempt(attempts[len(attempts)-1]), delay.Round(time.Millisecond)))
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			result.Error = ctx.Err()
+			progressBar.SetError(result.Error)
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		progressBar.SetProgressState(ui.Unknown)
+		progressBar.SetPercentage(0)
+	}
+	result.Attempts = attempts
+	return result
+}
 
-	progressTrackers := ui.NewProgressTrackers()
-	progressBars := make([]*ui.ProgressBar, requestCount)
-	for i, request := range requests {
-		url := request.Request.URL.String()
-		subtitle := "(" + request.Request.Method + " " + url + ")"
-		progressBars[i] = progressTrackers.AddProgressBar(request.Pod.Name, subtitle)
+func describeAttempt(attempt request.Attempt) string {
+	if attempt.StatusCode != 0 {
+		return http.StatusText(attempt.StatusCode)
 	}
-	for idx, req := range requests {
-		wgReq.Add(1)
-		go func() {
-			response, err := requestWithClient(clientFactory, &req)
-			if err != nil {
-				println(err.Error())
-				wgReq.Done()
-				return
-			}
-			defer response.Body.Close()
-			index := uint64(idx)
-
-			progressBars[index].SetText(response.Status)
-			if response.StatusCode >= 200 && response.StatusCode < 300 {
-				progressBars[index].SetProgressState(ui.Success)
-			} else if response.StatusCode >= 400 {
-				progressBars[index].SetProgressState(ui.Failure)
-			}
+	if attempt.Error != nil {
+		return attempt.Error.Error()
+	}
+	return "unknown error"
+}
 
-			contentLength := float64(response.ContentLength)
-			if contentLength < 0 {
-				// println("unknown content length")
-				// TODO: Handle with spinner
-			}
+func addProgressBar(progressTrackers *ui.ProgressTrackers, podRequest *request.PodRequest, transport request.Transport) *ui.ProgressBar {
+	url := podRequest.Request.URL.String()
+	subtitle := "(" + podRequest.Request.Method + " " + url + " via " + transport.Name() + ")"
+	return progressTrackers.AddProgressBar(podRequest.Name(), subtitle)
+}
 
-			bodyBuffer := NewLengthWriter(func(uint64, currentLength uint64) {
-				progressBars[index].SetPercentage(float64(currentLength) / contentLength)
-			})
+func addProgressBars(progressTrackers *ui.ProgressTrackers, requests []request.PodRequest, transport request.Transport) []*ui.ProgressBar {
+	progressBars := make([]*ui.ProgressBar, len(requests))
+	for i := range requests {
+		progressBars[i] = addProgressBar(progressTrackers, &requests[i], transport)
+	}
+	return progressBars
+}
 
-			teeReader := io.TeeReader(response.Body, bodyBuffer)
-			// TODO: use body
-			body, _ := io.ReadAll(teeReader)
-			responses[idx] = &PodHttpResponse{
-				Body: body,
-			}
-			progressBars[index].SetContent(string(body))
-			wgReq.Done()
+func throttledRequest(ctx context.Context, transport request.Transport, podRequest *request.PodRequest, progressBar *ui.ProgressBar, throttle *util.Throttle, retryPolicy *util.RetryPolicy, timeout time.Duration) *request.PodHttpResponse {
+	if podRequest.Err != nil {
+		progressBar.SetError(podRequest.Err)
+		return &request.PodHttpResponse{Pod: podRequest.Pod, Transport: transport.Name(), Error: podRequest.Err}
+	}
+	if throttle.Limited() {
+		progressBar.SetProgressState(ui.Queued)
+		progressBar.SetText("queued")
+	}
+	if err := throttle.Acquire(ctx); err != nil {
+		progressBar.SetError(err)
+		return &request.PodHttpResponse{Pod: podRequest.Pod, Transport: transport.Name(), Error: err}
+	}
+	defer throttle.Release()
+	if throttle.Limited() {
+		progressBar.SetProgressState(ui.Unknown)
+		progressBar.SetText("")
+	}
+
+	return requestWithRetries(ctx, transport, podRequest, progressBar, retryPolicy, timeout)
+}
+
+// fanOut requests every pod at once (within the throttle), each response is
+// written to the output as soon as it arrives
+func fanOut(ctx context.Context, transport request.Transport, requests []request.PodRequest, progressBars []*ui.ProgressBar, throttle *util.Throttle, output *request.OutputWriter, retryPolicy *util.RetryPolicy, timeout time.Duration) []*request.PodHttpResponse {
+	var wgReq sync.WaitGroup
+	responses := make([]*request.PodHttpResponse, len(requests))
+	for idx, req := range requests {
+		wgReq.Add(1)
+		go func() {
+			defer wgReq.Done()
+			responses[idx] = throttledRequest(ctx, transport, &req, progressBars[idx], throttle, retryPolicy, timeout)
+			output.Write(&req, responses[idx])
 		}()
 	}
+	wgReq.Wait()
+	return responses
+}
+
+func requestsWithClient(ctx context.Context, transport request.Transport, requests []request.PodRequest, throttle *util.Throttle, output *request.OutputWriter, retryPolicy *util.RetryPolicy, timeout time.Duration) []*request.PodHttpResponse {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	progressTrackers := ui.NewProgressTrackers(cancel)
+	progressBars := addProgressBars(progressTrackers, requests, transport)
 
 	progressTrackers.RunAsync()
-	wgReq.Wait()
+	responses := fanOut(ctx, transport, requests, progressBars, throttle, output, retryPolicy, timeout)
 
 	progressTrackers.Finish()
 	progressTrackers.Wait()
@@ -123,74 +215,185 @@ func requestsWithClient(clientFactory ClientFactory, requests []PodRequest) []*P
 	return responses
 }
 
-type PodRequest struct {
-	Pod     *v1.Pod
-	Request *http.Request
-}
-
-func httpRequests(pods *v1.PodList, method, protocol string, port uint16, headers map[string]string, path string) ([]PodRequest, error) {
-	requests := []PodRequest{}
-	for _, pod := range pods.Items {
-		podIP := pod.Status.PodIP
-		url := fmt.Sprintf("%s://%s:%d%s", protocol, podIP, port, path)
-		req, err := http.NewRequest(method, url, nil)
-		if err != nil {
-			return nil, err
+// outcomeError reports the pods whose requests failed, with an exit code that
+// tells pods the port forward could not reach apart from HTTP failures
+func outcomeError(responses []*request.PodHttpResponse) error {
+	unreachable := []string{}
+	failed := []string{}
+	for _, response := range responses {
+		switch {
+		case response == nil:
+		case response.Error != nil:
+			if state, _ := ui.ErrorState(response.Error); state == ui.Unreachable {
+				unreachable = append(unreachable, response.Pod.Name)
+			} else {
+				failed = append(failed, response.Pod.Name)
+			}
+		case response.Response.StatusCode >= 400:
+			failed = append(failed, response.Pod.Name)
 		}
-
-		for headerName, headerValue := range headers {
-			req.Header.Add(headerName, headerValue)
+	}
+	if len(unreachable) > 0 {
+		return &util.ExitError{
+			Code: util.ExitTunnelFailed,
+			Err:  fmt.Errorf("Unable to reach %d pods through the port forward: %s", len(unreachable), strings.Join(unreachable, ", ")),
 		}
-		requests = append(requests, PodRequest{
-			Pod:     &pod,
-			Request: req,
-		})
 	}
-	return requests, nil
+	if len(failed) > 0 {
+		return &util.ExitError{
+			Code: util.ExitRequestFailed,
+			Err:  fmt.Errorf("Requests to %d pods failed: %s", len(failed), strings.Join(failed, ", ")),
+		}
+	}
+	return nil
 }
 
 type SailArgs struct {
-	Protocol    string
-	ServiceName string
-	Port        uint16
-	Path        string
-	Method      string
-	Headers     map[string]string
+	*request.Args
+	Parallelism     uint
+	Rate            float64
+	OverallTimeout  time.Duration
+	RetryPolicy     *util.RetryPolicy
+	Watch           time.Duration
+	FollowPods      bool
+	BatchSize       string
+	HealthPath      string
+	HealthTimeout   time.Duration
+	SaveRun         string
+	RetryFailedFrom string
+	Output          request.OutputFormat
+}
+
+// selectFailedPods narrows the target down to the pods that failed in a
+// previous run, failed pods that have since been replaced are reported
+func selectFailedPods(target *request.Target, namespace string, sailArgs *SailArgs) error {
+	record, err := request.LoadRunRecord(sailArgs.RetryFailedFrom)
+	if err != nil {
+		return err
+	}
+	if err := record.CheckMatches(sailArgs.Args, namespace); err != nil {
+		return err
+	}
+
+	var missing []string
+	target.Pods, missing = record.SelectFailed(target.Pods)
+	for _, reason := range missing {
+		fmt.Fprintf(os.Stderr, "Skipping pod that failed in run %s: %s\n", record.ID, reason)
+	}
+	if len(target.Pods.Items) == 0 && len(missing) == 0 {
+		fmt.Fprintf(os.Stderr, "Run %s has no failed pods\n", record.ID)
+	} else if len(target.Pods.Items) == 0 {
+		return fmt.Errorf("None of the pods that failed in run %s still exist", record.ID)
+	}
+	return nil
 }
 
 func parseSailArgs(cmd *cobra.Command, args []string) (*SailArgs, error) {
-	protocol, err := cmd.Flags().GetString("protocol")
+	requestArgs, err := request.ParseArgs(cmd, args)
 	if err != nil {
 		return nil, err
 	}
-	var port uint16
-	if !cmd.Flags().Changed("port") {
-		port, err = util.GetDefaultPortForProtocol(&protocol)
-		if err != nil {
-			return nil, err
-		}
-	} else {
-		port, err = cmd.Flags().GetUint16("port")
-		if err != nil {
-			return nil, err
-		}
+	parallelism, err := cmd.Flags().GetUint("parallelism")
+	if err != nil {
+		return nil, err
+	}
+	rateFlag, err := cmd.Flags().GetString("rate")
+	if err != nil {
+		return nil, err
+	}
+	requestRate, err := util.ParseRate(rateFlag)
+	if err != nil {
+		return nil, err
+	}
+	overallTimeout, err := cmd.Flags().GetDuration("overall-timeout")
+	if err != nil {
+		return nil, err
+	}
+	retries, err := cmd.Flags().GetUint("retries")
+	if err != nil {
+		return nil, err
+	}
+	retryBackoff, err := cmd.Flags().GetDuration("retry-backoff")
+	if err != nil {
+		return nil, err
+	}
+	retryMaxBackoff, err := cmd.Flags().GetDuration("retry-max-backoff")
+	if err != nil {
+		return nil, err
+	}
+	retryOn, err := cmd.Flags().GetStringSlice("retry-on")
+	if err != nil {
+		return nil, err
+	}
+	retryPolicy, err := util.NewRetryPolicy(retries, retryBackoff, retryMaxBackoff, retryOn)
+	if err != nil {
+		return nil, err
+	}
+	watch, err := cmd.Flags().GetDuration("watch")
+	if err != nil {
+		return nil, err
 	}
-	method, err := cmd.Flags().GetString("method")
+	followPods, err := cmd.Flags().GetBool("follow-pods")
 	if err != nil {
 		return nil, err
 	}
-	headers, err := cmd.Flags().GetStringToString("header")
+	batchSize, err := cmd.Flags().GetString("batch-size")
 	if err != nil {
 		return nil, err
 	}
+	healthPath, err := cmd.Flags().GetString("health-path")
+	if err != nil {
+		return nil, err
+	}
+	healthTimeout, err := cmd.Flags().GetDuration("health-timeout")
+	if err != nil {
+		return nil, err
+	}
+	if batchSize != "" && (watch > 0 || followPods) {
+		return nil, fmt.Errorf("--batch-size cannot be combined with --watch or --follow-pods")
+	}
+	if healthPath != "" && batchSize == "" {
+		return nil, fmt.Errorf("--health-path requires --batch-size")
+	}
+	saveRun, err := cmd.Flags().GetString("save-run")
+	if err != nil {
+		return nil, err
+	}
+	retryFailedFrom, err := cmd.Flags().GetString("retry-failed-from")
+	if err != nil {
+		return nil, err
+	}
+	if (saveRun != "" || retryFailedFrom != "") && (watch > 0 || followPods) {
+		return nil, fmt.Errorf("--save-run and --retry-failed-from cannot be combined with --watch or --follow-pods")
+	}
+	outputFlag, err := cmd.Flags().GetString("output")
+	if err != nil {
+		return nil, err
+	}
+	output, err := request.ParseOutputFormat(outputFlag)
+	if err != nil {
+		return nil, err
+	}
+	// json and yaml are only written once the run is over, which would keep
+	// every round in memory
+	if (output == request.OutputJSON || output == request.OutputYAML) && (watch > 0 || followPods) {
+		return nil, fmt.Errorf("--output %s cannot be combined with --watch or --follow-pods, use ndjson", output)
+	}
 
 	return &SailArgs{
-		Protocol:    protocol,
-		ServiceName: args[0],
-		Port:        port,
-		Path:        args[1],
-		Method:      method,
-		Headers:     headers,
+		Args:            requestArgs,
+		Parallelism:     parallelism,
+		Rate:            requestRate,
+		OverallTimeout:  overallTimeout,
+		RetryPolicy:     retryPolicy,
+		Watch:           watch,
+		FollowPods:      followPods,
+		BatchSize:       batchSize,
+		HealthPath:      healthPath,
+		HealthTimeout:   healthTimeout,
+		SaveRun:         saveRun,
+		RetryFailedFrom: retryFailedFrom,
+		Output:          output,
 	}, nil
 }
 
@@ -204,75 +407,102 @@ func Cmd() *cobra.Command {
 			if err != nil {
 				return err
 			}
+			ctx, cancel := util.WithOptionalTimeout(cmd.Context(), sailArgs.OverallTimeout)
+			defer cancel()
+
 			kubeClient, err := kube.GetClientUsingFlags(cmd)
 			if err != nil {
 				return err
 			}
-			// TODO: Should have way to override this behaviour
-			pods, service, _ := kube.GetPodsForService(cmd.Context(), kubeClient, &sailArgs.ServiceName)
-			actualPort := sailArgs.Port
-			for _, port := range service.Spec.Ports {
-				if port.Port == int32(sailArgs.Port) {
-					actualPort = uint16(port.TargetPort.IntVal)
-					break
+			throttle := util.NewThrottle(sailArgs.Parallelism, sailArgs.Rate)
+			output := request.NewOutputWriter(sailArgs.Output, os.Stdout)
+			if sailArgs.FollowPods {
+				target, err := request.ResolveServiceTarget(ctx, kubeClient, sailArgs.ServiceName, sailArgs.Port)
+				if err != nil {
+					return err
+				}
+				transport, err := request.NewTransport(kubeClient, target.Port, sailArgs.Args)
+				if err != nil {
+					return err
 				}
+				defer transport.Close()
+				err = followWithClient(ctx, kubeClient, target, transport, throttle, output, sailArgs)
+				return errors.Join(err, output.Flush())
 			}
-			requests, err := httpRequests(
-				pods,
-				sailArgs.Method,
-				sailArgs.Protocol,
-				actualPort,
-				sailArgs.Headers,
-				sailArgs.Path,
-			)
+
+			target, err := request.ResolveTarget(ctx, kubeClient, sailArgs.ServiceName, sailArgs.Port)
 			if err != nil {
 				return err
 			}
-
-			// var responses []*PodHttpResponse
-			if kubeClient.ClientType == kube.InCluster {
-				inClusterHttpClientFactory := func(_ *PodRequest) (*http.Client, ClientCloser, error) {
-					return http.DefaultClient, nil, nil
+			if sailArgs.RetryFailedFrom != "" {
+				if err := selectFailedPods(target, kubeClient.Namespace, sailArgs); err != nil {
+					return err
 				}
-				/*responses = */ requestsWithClient(inClusterHttpClientFactory, requests)
+				if len(target.Pods.Items) == 0 {
+					return output.Flush()
+				}
+			}
+			requests, err := target.Requests(ctx, sailArgs.Args)
+			if err != nil {
+				return err
+			}
+
+			transport, err := request.NewTransport(kubeClient, target.Port, sailArgs.Args)
+			if err != nil {
+				return err
+			}
+			defer transport.Close()
+			if sailArgs.Watch > 0 {
+				watchWithClient(ctx, transport, requests, throttle, output, sailArgs.RetryPolicy, sailArgs.Timeout, sailArgs.Watch)
+				return output.Flush()
+			}
+
+			startedAt := time.Now()
+			var responses []*request.PodHttpResponse
+			var runErr error
+			if sailArgs.BatchSize != "" {
+				responses, runErr = batchedWithClient(ctx, kubeClient, target, transport, requests, throttle, output, sailArgs)
 			} else {
-				outOfClusterHttpClientFactory := func(podRequest *PodRequest) (*http.Client, ClientCloser, error) {
-					portForward, err := kube.PortForward(kubeClient, podRequest.Pod, actualPort)
-					if err != nil {
-						return nil, nil, err
-					}
-
-					transport := &http.Transport{
-						DialContext: func(ctx context.Context, network string, addr string) (net.Conn, error) {
-							return kube.NewPodConn(podRequest.Pod, portForward.DataStream), nil
-						},
-					}
-					client := http.Client{
-						Transport: transport,
-					}
-					return &client, func() { portForward.Close() }, nil
+				responses = requestsWithClient(ctx, transport, requests, throttle, output, sailArgs.RetryPolicy, sailArgs.Timeout)
+			}
+			if sailArgs.SaveRun != "" && responses != nil {
+				record := request.NewRunRecord(sailArgs.Args, kubeClient.Namespace, startedAt, requests, responses)
+				if err := record.Save(sailArgs.SaveRun); err != nil {
+					return err
+				}
+			}
+			for idx, response := range responses {
+				// Pods in waves that never started are still reported
+				if response == nil {
+					output.Write(&requests[idx], nil)
 				}
-				/*responses = */ requestsWithClient(outOfClusterHttpClientFactory, requests)
 			}
-			// println(responses)
-			// TODO: add proper ui instead of temporary printout
-			// for _, response := range responses {
-			// println(response == nil)
-			// if response.Error != nil {
-			// 	// println(response.Pod.Name + ": " + response.Error.Error())
-			// } else {
-			// 	// println(response.Pod.Name + ": " + strconv.Itoa(response.Response.StatusCode))
-			// }
-			// }
-			return nil
+			if err := output.Flush(); err != nil {
+				return err
+			}
+			if runErr != nil {
+				return runErr
+			}
+			return outcomeError(responses)
 		},
 	}
 
-	sailCommand.Flags().StringP("method", "m", "GET", "The HTTP Method to use")
-	// TODO: Should probs support duplicate headers, we currently do not oooops
-	sailCommand.Flags().StringToStringP("header", "H", map[string]string{}, "The HTTP header to add in the form name=value")
-	sailCommand.Flags().Uint16P("port", "p", 0, "The port to use for the reques (by default this is inferred from protocol)")
-	sailCommand.Flags().StringP("protocol", "P", "http", "The protocol to use (http/https)")
+	request.AddFlags(sailCommand.Flags())
+	sailCommand.Flags().Uint("parallelism", 0, "The maximum number of pods to request at once (0 for unlimited)")
+	sailCommand.Flags().String("rate", "", "The maximum rate to start requests at across all pods in the form R/s, R/m or R/h")
+	sailCommand.Flags().Duration("overall-timeout", 0, "The maximum time the whole fan-out may take (0 for no timeout)")
+	sailCommand.Flags().Uint("retries", 0, "The number of times to retry a pod request that fails with a --retry-on condition")
+	sailCommand.Flags().Duration("retry-backoff", 200*time.Millisecond, "The initial delay between retries, doubled (with jitter) on each retry")
+	sailCommand.Flags().Duration("retry-max-backoff", 30*time.Second, "The longest delay between retries, also caps a server's Retry-After (0 for no limit)")
+	sailCommand.Flags().Duration("watch", 0, "Repeat the fan-out at this interval, keeping a history of each pod's responses (0 to run once)")
+	sailCommand.Flags().Bool("follow-pods", false, "Keep running and send requests to pods as they become Ready, until interrupted or --overall-timeout")
+	sailCommand.Flags().String("batch-size", "", "Send requests in waves of this many pods (e.g. 3) or this percentage of pods (e.g. 10%), capped by any PodDisruptionBudget")
+	sailCommand.Flags().String("health-path", "", "A path to GET on every processed pod between waves, each wave must pass before the next starts")
+	sailCommand.Flags().Duration("health-timeout", time.Minute, "How long to wait between waves for processed pods to pass the health check, and for the PodDisruptionBudget to allow disruptions")
+	sailCommand.Flags().String("save-run", "", "Write the outcome of every pod request to this file, for use with --retry-failed-from")
+	sailCommand.Flags().String("retry-failed-from", "", "Only send requests to the pods that failed in the run saved to this file")
+	sailCommand.Flags().StringP("output", "o", "", "Write a record of every pod request to stdout as json or yaml sorted by pod once the run is over, or as ndjson (one record per line as each response arrives, also with --watch and --follow-pods)")
+	sailCommand.Flags().StringSlice("retry-on", []string{"502", "503", "504", util.ConnectError}, "The status codes and conditions to retry on, connect-error covers any failure before a response is received")
 
 	return sailCommand
 }
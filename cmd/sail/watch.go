@@ -0,0 +1,129 @@
+package sail
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/mini-ninja-64/flotilla/internal/request"
+	"github.com/mini-ninja-64/flotilla/internal/ui"
+	"github.com/mini-ninja-64/flotilla/internal/util"
+)
+
+// podOutcome is what we compare between rounds to decide if a pod's
+// behaviour has changed
+type podOutcome struct {
+	statusCode int
+	bodyHash   string
+	errorText  string
+}
+
+func outcomeOf(response *request.PodHttpResponse) podOutcome {
+	if response.Error != nil {
+		_, text := ui.ErrorState(response.Error)
+		return podOutcome{errorText: text}
+	}
+	bodyHash := sha256.Sum256(response.Body)
+	return podOutcome{
+		statusCode: response.Response.StatusCode,
+		bodyHash:   hex.EncodeToString(bodyHash[:4]),
+	}
+}
+
+func (outcome podOutcome) String() string {
+	if outcome.errorText != "" {
+		return outcome.errorText
+	}
+	return fmt.Sprintf("%d %s", outcome.statusCode, http.StatusText(outcome.statusCode))
+}
+
+func describeChange(podName string, previous podOutcome, current podOutcome) string {
+	timestamp := time.Now().Format(time.TimeOnly)
+	if previous.statusCode == current.statusCode && previous.errorText == current.errorText {
+		return fmt.Sprintf("%s %s: response changed (%s → %s)", timestamp, podName, previous.bodyHash, current.bodyHash)
+	}
+	return fmt.Sprintf("%s %s: %s → %s", timestamp, podName, previous, current)
+}
+
+func historyEntryFor(response *request.PodHttpResponse, changed bool) ui.HistoryEntry {
+	entry := ui.HistoryEntry{
+		State:   ui.Unknown,
+		Changed: changed,
+	}
+	if len(response.Attempts) > 0 {
+		entry.Latency = response.Attempts[len(response.Attempts)-1].Duration
+	}
+	switch {
+	case response.Error != nil:
+		entry.State, _ = ui.ErrorState(response.Error)
+	case response.Response.StatusCode >= 200 && response.Response.StatusCode < 300:
+		entry.State = ui.Success
+	case response.Response.StatusCode >= 400:
+		entry.State = ui.Failure
+	}
+	return entry
+}
+
+// podHistory tracks a pod's outcomes between rounds so changes can be
+// highlighted
+type podHistory struct {
+	podName  string
+	previous *podOutcome
+}
+
+func (history *podHistory) record(progressTrackers *ui.ProgressTrackers, progressBar *ui.ProgressBar, response *request.PodHttpResponse) {
+	outcome := outcomeOf(response)
+	changed := history.previous != nil && *history.previous != outcome
+	if changed {
+		progressTrackers.LogChange(describeChange(history.podName, *history.previous, outcome))
+	}
+	progressBar.AddHistory(historyEntryFor(response, changed))
+	history.previous = &outcome
+}
+
+// watchWithClient repeats the fan-out every interval until cancelled, each
+// round is added to the pod's history and any change in status or response
+// body is written to the change log
+func watchWithClient(ctx context.Context, transport request.Transport, requests []request.PodRequest, throttle *util.Throttle, output *request.OutputWriter, retryPolicy *util.RetryPolicy, timeout time.Duration, interval time.Duration) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	progressTrackers := ui.NewProgressTrackers(cancel)
+	request.OnTunnelEvent(transport, progressTrackers.LogTunnelEvent)
+	progressBars := addProgressBars(progressTrackers, requests, transport)
+	histories := make([]podHistory, len(requests))
+	for idx, podRequest := range requests {
+		histories[idx].podName = podRequest.Name()
+	}
+
+	progressTrackers.RunAsync()
+	for {
+		roundStart := time.Now()
+		responses := fanOut(ctx, transport, requests, progressBars, throttle, output, retryPolicy, timeout)
+		// A cancelled round tells us nothing about the pods
+		if ctx.Err() != nil {
+			break
+		}
+
+		for idx, response := range responses {
+			histories[idx].record(progressTrackers, progressBars[idx], response)
+		}
+
+		select {
+		case <-time.After(interval - time.Since(roundStart)):
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		for _, progressBar := range progressBars {
+			progressBar.SetPercentage(0)
+		}
+	}
+
+	progressTrackers.Finish()
+	progressTrackers.Wait()
+}
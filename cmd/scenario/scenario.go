@@ -0,0 +1,149 @@
+package scenario
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/mini-ninja-64/flotilla/internal/kube"
+	"github.com/mini-ninja-64/flotilla/internal/request"
+	"github.com/mini-ninja-64/flotilla/internal/scenario"
+	"github.com/mini-ninja-64/flotilla/internal/ui"
+	"github.com/mini-ninja-64/flotilla/internal/util"
+	"github.com/spf13/cobra"
+	v1 "k8s.io/api/core/v1"
+)
+
+type ScenarioArgs struct {
+	*request.Args
+	Scenario *scenario.Scenario
+}
+
+// runSteps runs every step of the scenario against a single pod, stopping at
+// the first step that fails
+func runSteps(ctx context.Context, transport request.Transport, pod *v1.Pod, target *request.Target, scenarioArgs *ScenarioArgs, progressBar *ui.ProgressBar) error {
+	steps := scenarioArgs.Scenario.Steps
+	ip, host, err := scenarioArgs.Addressing(target.Service).First(pod)
+	if err != nil {
+		return err
+	}
+	variables := scenario.NewVariables(pod, ip)
+	for i, step := range steps {
+		progressBar.SetStage(uint(i+1), uint(len(steps)), step.Name)
+		progressBar.SetPercentage(float64(i) / float64(len(steps)))
+
+		podRequest, err := step.Request(ctx, pod, ip, host, scenarioArgs.Args, target.Port, variables)
+		if err != nil {
+			return fmt.Errorf("%s: %w", step.Name, err)
+		}
+		requestCtx, cancel := util.WithOptionalTimeout(ctx, scenarioArgs.Timeout)
+		response := request.Do(requestCtx, transport, podRequest)
+		cancel()
+		if err := step.Check(response, variables); err != nil {
+			return fmt.Errorf("%s: %w", step.Name, err)
+		}
+		progressBar.SetText(response.Response.Status)
+	}
+	progressBar.SetPercentage(1)
+	return nil
+}
+
+func runScenario(ctx context.Context, transport request.Transport, target *request.Target, scenarioArgs *ScenarioArgs) error {
+	var wg sync.WaitGroup
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	progressTrackers := ui.NewProgressTrackers(cancel)
+	errs := make([]error, len(target.Pods.Items))
+	for idx := range target.Pods.Items {
+		pod := &target.Pods.Items[idx]
+		address := pod.Status.PodIP
+		if _, host, err := scenarioArgs.Addressing(target.Service).First(pod); err == nil && host != "" {
+			address = host
+		}
+		progressBar := progressTrackers.AddProgressBar(pod.Name, "("+address+" via "+transport.Name()+")")
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[idx] = runSteps(ctx, transport, pod, target, scenarioArgs, progressBar)
+			if errs[idx] != nil {
+				progressBar.SetError(errs[idx])
+				return
+			}
+			progressBar.SetProgressState(ui.Success)
+			progressBar.SetText(fmt.Sprintf("completed %d steps", len(scenarioArgs.Scenario.Steps)))
+		}()
+	}
+
+	progressTrackers.RunAsync()
+	wg.Wait()
+
+	progressTrackers.Finish()
+	progressTrackers.Wait()
+
+	failures := 0
+	for _, err := range errs {
+		if err != nil {
+			failures++
+		}
+	}
+	if failures > 0 {
+		return fmt.Errorf("Scenario failed on %d of %d pods", failures, len(errs))
+	}
+	return nil
+}
+
+func parseScenarioArgs(cmd *cobra.Command, args []string) (*ScenarioArgs, error) {
+	requestArgs, err := request.ParseConnectionArgs(cmd, args[0], "timeout")
+	if err != nil {
+		return nil, err
+	}
+	if err := requestArgs.ErrUnlessSingleFamily("scenario"); err != nil {
+		return nil, err
+	}
+	loadedScenario, err := scenario.Load(args[1])
+	if err != nil {
+		return nil, err
+	}
+	return &ScenarioArgs{
+		Args:     requestArgs,
+		Scenario: loadedScenario,
+	}, nil
+}
+
+func Cmd() *cobra.Command {
+	var scenarioCommand = &cobra.Command{
+		Use:   "scenario [service] [scenario-file]",
+		Short: "Run a sequence of HTTP requests against every pod in a service",
+		Long: "Run a sequence of HTTP requests against every pod in a service.\n\n" +
+			"Steps run in order for each pod, and in parallel across pods. Values extracted from a " +
+			"response (with jsonpath, regex or header) can be used in later steps as {{ .name }}.",
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			scenarioArgs, err := parseScenarioArgs(cmd, args)
+			if err != nil {
+				return err
+			}
+			ctx := cmd.Context()
+
+			kubeClient, err := kube.GetClientUsingFlags(cmd)
+			if err != nil {
+				return err
+			}
+			target, err := request.ResolveTarget(ctx, kubeClient, scenarioArgs.ServiceName, scenarioArgs.Port)
+			if err != nil {
+				return err
+			}
+			transport, err := request.NewTransport(kubeClient, target.Port, scenarioArgs.Args)
+			if err != nil {
+				return err
+			}
+			defer transport.Close()
+			return runScenario(ctx, transport, target, scenarioArgs)
+		},
+	}
+
+	request.AddConnectionFlags(scenarioCommand.Flags(), "timeout")
+
+	return scenarioCommand
+}
@@ -0,0 +1,189 @@
+package tunnel
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+const (
+	socksVersion          = 0x05
+	socksNoAuth           = 0x00
+	socksNoAcceptableAuth = 0xff
+	socksConnect          = 0x01
+	socksIPv4             = 0x01
+	socksDomain           = 0x03
+	socksIPv6             = 0x04
+
+	socksSucceeded           = 0x00
+	socksGeneralFailure      = 0x01
+	socksHostUnreachable     = 0x04
+	socksCommandNotSupported = 0x07
+	socksAddressNotSupported = 0x08
+)
+
+// handshake is the protocol specific part of accepting a connection, it
+// reads where the client wants to go and replies once that is known
+type handshake interface {
+	destination() (host string, port uint16)
+	accept() error
+	reject(err error) error
+}
+
+// readHandshake works out which protocol the client speaks from the first
+// byte, SOCKS starts with its version and anything else is taken as HTTP
+func readHandshake(conn net.Conn, reader *bufio.Reader) (handshake, error) {
+	first, err := reader.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	if first[0] == socksVersion {
+		return readSocksHandshake(conn, reader)
+	}
+	return readConnectHandshake(conn, reader)
+}
+
+type socksHandshake struct {
+	conn net.Conn
+	host string
+	port uint16
+}
+
+func readSocksHandshake(conn net.Conn, reader *bufio.Reader) (*socksHandshake, error) {
+	greeting := make([]byte, 2)
+	if _, err := io.ReadFull(reader, greeting); err != nil {
+		return nil, err
+	}
+	methods := make([]byte, greeting[1])
+	if _, err := io.ReadFull(reader, methods); err != nil {
+		return nil, err
+	}
+	noAuth := false
+	for _, method := range methods {
+		noAuth = noAuth || method == socksNoAuth
+	}
+	if !noAuth {
+		conn.Write([]byte{socksVersion, socksNoAcceptableAuth})
+		return nil, fmt.Errorf("SOCKS client does not support connecting without authentication")
+	}
+	if _, err := conn.Write([]byte{socksVersion, socksNoAuth}); err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, err
+	}
+	handshake := &socksHandshake{conn: conn}
+	if header[1] != socksConnect {
+		handshake.reply(socksCommandNotSupported)
+		return nil, fmt.Errorf("Unsupported SOCKS command %d, only CONNECT is supported", header[1])
+	}
+	switch header[3] {
+	case socksIPv4, socksIPv6:
+		address := make([]byte, net.IPv4len)
+		if header[3] == socksIPv6 {
+			address = make([]byte, net.IPv6len)
+		}
+		if _, err := io.ReadFull(reader, address); err != nil {
+			return nil, err
+		}
+		handshake.host = net.IP(address).String()
+	case socksDomain:
+		length, err := reader.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		domain := make([]byte, length)
+		if _, err := io.ReadFull(reader, domain); err != nil {
+			return nil, err
+		}
+		handshake.host = string(domain)
+	default:
+		handshake.reply(socksAddressNotSupported)
+		return nil, fmt.Errorf("Unsupported SOCKS address type %d", header[3])
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(reader, port); err != nil {
+		return nil, err
+	}
+	handshake.port = binary.BigEndian.Uint16(port)
+	return handshake, nil
+}
+
+// reply reports the outcome of the request, the bound address is always
+// reported as 0.0.0.0:0 as there is no local socket for the connection
+func (handshake *socksHandshake) reply(status byte) error {
+	_, err := handshake.conn.Write([]byte{socksVersion, status, 0x00, socksIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
+
+func (handshake *socksHandshake) destination() (string, uint16) {
+	return handshake.host, handshake.port
+}
+
+func (handshake *socksHandshake) accept() error {
+	return handshake.reply(socksSucceeded)
+}
+
+func (handshake *socksHandshake) reject(err error) error {
+	var lookupErr *podLookupError
+	if errors.As(err, &lookupErr) {
+		return handshake.reply(socksHostUnreachable)
+	}
+	return handshake.reply(socksGeneralFailure)
+}
+
+type connectHandshake struct {
+	conn net.Conn
+	host string
+	port uint16
+}
+
+func readConnectHandshake(conn net.Conn, reader *bufio.Reader) (*connectHandshake, error) {
+	req, err := http.ReadRequest(reader)
+	if err != nil {
+		return nil, err
+	}
+	if req.Method != http.MethodConnect {
+		writeStatus(conn, http.StatusMethodNotAllowed)
+		return nil, fmt.Errorf("Unsupported HTTP method %s, only CONNECT is supported", req.Method)
+	}
+	host, portText, err := net.SplitHostPort(req.Host)
+	if err != nil {
+		writeStatus(conn, http.StatusBadRequest)
+		return nil, err
+	}
+	port, err := strconv.ParseUint(portText, 10, 16)
+	if err != nil {
+		writeStatus(conn, http.StatusBadRequest)
+		return nil, err
+	}
+	return &connectHandshake{conn: conn, host: host, port: uint16(port)}, nil
+}
+
+func writeStatus(conn net.Conn, status int) error {
+	_, err := fmt.Fprintf(conn, "HTTP/1.1 %d %s\r\n\r\n", status, http.StatusText(status))
+	return err
+}
+
+func (handshake *connectHandshake) destination() (string, uint16) {
+	return handshake.host, handshake.port
+}
+
+func (handshake *connectHandshake) accept() error {
+	return writeStatus(handshake.conn, http.StatusOK)
+}
+
+func (handshake *connectHandshake) reject(err error) error {
+	var lookupErr *podLookupError
+	if errors.As(err, &lookupErr) {
+		return writeStatus(handshake.conn, http.StatusNotFound)
+	}
+	return writeStatus(handshake.conn, http.StatusBadGateway)
+}
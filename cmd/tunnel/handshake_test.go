@@ -0,0 +1,151 @@
+package tunnel
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+// handshakeClient runs the server side of a handshake against a client that
+// writes request, returning what the server replied
+func handshakeClient(t *testing.T, request []byte, finish func(handshake) error) (handshake, []byte, error) {
+	t.Helper()
+	client, server := net.Pipe()
+	replies := make(chan []byte)
+	go func() {
+		client.Write(request)
+		reply, _ := io.ReadAll(client)
+		replies <- reply
+	}()
+
+	result, err := readHandshake(server, bufio.NewReader(server))
+	if err == nil && finish != nil {
+		finish(result)
+	}
+	server.Close()
+	return result, <-replies, err
+}
+
+func TestSocksHandshake(t *testing.T) {
+	tests := []struct {
+		name    string
+		address []byte
+		host    string
+	}{
+		{"ipv4", []byte{socksIPv4, 10, 0, 0, 1}, "10.0.0.1"},
+		{"ipv6", append([]byte{socksIPv6}, net.ParseIP("fd00::1")...), "fd00::1"},
+		{"domain", append([]byte{socksDomain, 7}, "pod-0.a"...), "pod-0.a"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := []byte{socksVersion, 2, 0x02, socksNoAuth}
+			request = append(request, socksVersion, socksConnect, 0x00)
+			request = append(request, test.address...)
+			request = append(request, 0x1f, 0x90)
+
+			result, reply, err := handshakeClient(t, request, handshake.accept)
+			if err != nil {
+				t.Fatal(err)
+			}
+			host, port := result.destination()
+			if host != test.host || port != 8080 {
+				t.Errorf("expected %s:8080, got %s:%d", test.host, host, port)
+			}
+			want := []byte{socksVersion, socksNoAuth, socksVersion, socksSucceeded, 0x00, socksIPv4, 0, 0, 0, 0, 0, 0}
+			if !bytes.Equal(reply, want) {
+				t.Errorf("expected reply %v, got %v", want, reply)
+			}
+		})
+	}
+}
+
+func TestSocksHandshakeRejections(t *testing.T) {
+	tests := []struct {
+		name    string
+		request []byte
+		reply   []byte
+	}{
+		{
+			"authentication required",
+			[]byte{socksVersion, 1, 0x02},
+			[]byte{socksVersion, socksNoAcceptableAuth},
+		},
+		{
+			"bind command",
+			[]byte{socksVersion, 1, socksNoAuth, socksVersion, 0x02, 0x00, socksIPv4},
+			[]byte{socksVersion, socksNoAuth, socksVersion, socksCommandNotSupported, 0x00, socksIPv4, 0, 0, 0, 0, 0, 0},
+		},
+		{
+			"unknown address type",
+			[]byte{socksVersion, 1, socksNoAuth, socksVersion, socksConnect, 0x00, 0x09},
+			[]byte{socksVersion, socksNoAuth, socksVersion, socksAddressNotSupported, 0x00, socksIPv4, 0, 0, 0, 0, 0, 0},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, reply, err := handshakeClient(t, test.request, nil)
+			if err == nil {
+				t.Fatal("expected the handshake to fail")
+			}
+			if !bytes.Equal(reply, test.reply) {
+				t.Errorf("expected reply %v, got %v", test.reply, reply)
+			}
+		})
+	}
+}
+
+func TestSocksRejectReportsUnreachablePods(t *testing.T) {
+	request := []byte{socksVersion, 1, socksNoAuth, socksVersion, socksConnect, 0x00, socksIPv4, 10, 0, 0, 1, 0x1f, 0x90}
+	_, reply, _ := handshakeClient(t, request, func(result handshake) error {
+		return result.reject(&podLookupError{host: "10.0.0.1", err: errors.New("not found")})
+	})
+	if status := reply[3]; status != socksHostUnreachable {
+		t.Errorf("expected host unreachable, got %d", status)
+	}
+}
+
+func TestConnectHandshake(t *testing.T) {
+	request := "CONNECT pod-0.web.default:8080 HTTP/1.1\r\nHost: pod-0.web.default:8080\r\n\r\n"
+	result, reply, err := handshakeClient(t, []byte(request), handshake.accept)
+	if err != nil {
+		t.Fatal(err)
+	}
+	host, port := result.destination()
+	if host != "pod-0.web.default" || port != 8080 {
+		t.Errorf("expected pod-0.web.default:8080, got %s:%d", host, port)
+	}
+	if !strings.HasPrefix(string(reply), "HTTP/1.1 200 ") {
+		t.Errorf("expected a 200 reply, got %q", reply)
+	}
+}
+
+func TestConnectHandshakeRejections(t *testing.T) {
+	tests := map[string]string{
+		"GET http://pod-0/ HTTP/1.1\r\nHost: pod-0\r\n\r\n":         "HTTP/1.1 405 ",
+		"CONNECT pod-0 HTTP/1.1\r\nHost: pod-0\r\n\r\n":             "HTTP/1.1 400 ",
+		"CONNECT pod-0:99999 HTTP/1.1\r\nHost: pod-0:99999\r\n\r\n": "HTTP/1.1 400 ",
+	}
+	for request, want := range tests {
+		_, reply, err := handshakeClient(t, []byte(request), nil)
+		if err == nil {
+			t.Errorf("expected %q to be rejected", request)
+		}
+		if !strings.HasPrefix(string(reply), want) {
+			t.Errorf("expected %q to reply %q, got %q", request, want, reply)
+		}
+	}
+}
+
+func TestConnectRejectReportsMissingPods(t *testing.T) {
+	request := "CONNECT pod-9:8080 HTTP/1.1\r\nHost: pod-9:8080\r\n\r\n"
+	_, reply, _ := handshakeClient(t, []byte(request), func(result handshake) error {
+		return result.reject(&podLookupError{host: "pod-9", err: errors.New("not found")})
+	})
+	if !strings.HasPrefix(string(reply), "HTTP/1.1 404 ") {
+		t.Errorf("expected a 404 reply, got %q", reply)
+	}
+}
@@ -0,0 +1,213 @@
+package tunnel
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/mini-ninja-64/flotilla/internal/kube"
+	"github.com/mini-ninja-64/flotilla/internal/ui"
+	"github.com/mini-ninja-64/flotilla/internal/util"
+	"github.com/spf13/cobra"
+	v1 "k8s.io/api/core/v1"
+)
+
+type TunnelArgs struct {
+	Listen              string
+	ConnectTimeout      time.Duration
+	PortForwardProtocol kube.PortForwardProtocol
+}
+
+// podLookupError means the destination is not a pod we can find, as opposed
+// to a pod we found but could not reach
+type podLookupError struct {
+	host string
+	err  error
+}
+
+func (err *podLookupError) Error() string {
+	return fmt.Sprintf("Unable to find a pod for '%s': %s", err.host, err.err)
+}
+
+func (err *podLookupError) Unwrap() error {
+	return err.err
+}
+
+// tunnelServer accepts SOCKS5 and HTTP CONNECT connections and splices each
+// one onto a port forward to the destination pod, tunnels are pooled per port
+// so connections to the same pod and port share one
+type tunnelServer struct {
+	kubeClient       *kube.KubeClient
+	tunnelArgs       *TunnelArgs
+	progressTrackers *ui.ProgressTrackers
+	mu               sync.Mutex
+	pools            map[uint16]*kube.TunnelPool
+}
+
+func (server *tunnelServer) pool(port uint16) *kube.TunnelPool {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	pool, ok := server.pools[port]
+	if !ok {
+		pool = kube.NewTunnelPool(server.kubeClient, port, server.tunnelArgs.PortForwardProtocol)
+		pool.OnEvent(server.progressTrackers.LogTunnelEvent)
+		server.pools[port] = pool
+	}
+	return pool
+}
+
+func (server *tunnelServer) close() {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	for _, pool := range server.pools {
+		pool.Close()
+	}
+}
+
+func (server *tunnelServer) dial(ctx context.Context, host string, port uint16) (net.Conn, *v1.Pod, error) {
+	connectCtx, cancel := util.WithOptionalTimeout(ctx, server.tunnelArgs.ConnectTimeout)
+	defer cancel()
+	pod, err := kube.FindPod(connectCtx, server.kubeClient, host)
+	if err != nil {
+		return nil, nil, &podLookupError{host: host, err: err}
+	}
+	pool := server.pool(port)
+	tunnel, err := pool.Get(connectCtx, pod)
+	if err != nil {
+		return nil, pod, err
+	}
+	conn, err := tunnel.Dial()
+	if err != nil {
+		pool.Evict(pod, tunnel, err)
+		return nil, pod, err
+	}
+	return conn, pod, nil
+}
+
+func (server *tunnelServer) log(format string, args ...any) {
+	server.progressTrackers.LogChange(time.Now().Format(time.TimeOnly) + " " + fmt.Sprintf(format, args...))
+}
+
+func (server *tunnelServer) handle(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	handshake, err := readHandshake(conn, reader)
+	if err != nil {
+		server.log("%s rejected: %s", conn.RemoteAddr(), err)
+		return
+	}
+
+	host, port := handshake.destination()
+	podConn, pod, err := server.dial(ctx, host, port)
+	if err != nil {
+		handshake.reject(err)
+		server.log("%s → %s:%d failed: %s", conn.RemoteAddr(), host, port, err)
+		return
+	}
+	defer podConn.Close()
+	if err := handshake.accept(); err != nil {
+		return
+	}
+
+	destination := fmt.Sprintf("%s/%s:%d", pod.Namespace, pod.Name, port)
+	server.log("%s → %s opened", conn.RemoteAddr(), destination)
+	start := time.Now()
+	sent, received := util.Splice(conn, reader, podConn)
+	server.log("%s → %s closed after %s (%d bytes sent, %d received)", conn.RemoteAddr(), destination, time.Since(start).Round(time.Millisecond), sent, received)
+}
+
+func runTunnel(ctx context.Context, kubeClient *kube.KubeClient, tunnelArgs *TunnelArgs) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	listener, err := net.Listen("tcp", tunnelArgs.Listen)
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	progressTrackers := ui.NewProgressTrackers(cancel)
+	progressTrackers.RunAsync()
+	server := &tunnelServer{
+		kubeClient:       kubeClient,
+		tunnelArgs:       tunnelArgs,
+		progressTrackers: progressTrackers,
+		pools:            map[uint16]*kube.TunnelPool{},
+	}
+	defer server.close()
+	server.log("listening on %s for SOCKS5 and HTTP CONNECT", listener.Addr())
+
+	var acceptErr error
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() == nil {
+				acceptErr = err
+			}
+			break
+		}
+		go server.handle(ctx, conn)
+	}
+
+	progressTrackers.Finish()
+	progressTrackers.Wait()
+	return acceptErr
+}
+
+func parseTunnelArgs(cmd *cobra.Command) (*TunnelArgs, error) {
+	listen, err := cmd.Flags().GetString("listen")
+	if err != nil {
+		return nil, err
+	}
+	connectTimeout, err := cmd.Flags().GetDuration("connect-timeout")
+	if err != nil {
+		return nil, err
+	}
+	portForwardProtocolFlag, err := cmd.Flags().GetString("portforward-protocol")
+	if err != nil {
+		return nil, err
+	}
+	portForwardProtocol, err := kube.ParsePortForwardProtocol(portForwardProtocolFlag)
+	if err != nil {
+		return nil, err
+	}
+	return &TunnelArgs{
+		Listen:              listen,
+		ConnectTimeout:      connectTimeout,
+		PortForwardProtocol: portForwardProtocol,
+	}, nil
+}
+
+func Cmd() *cobra.Command {
+	var tunnelCommand = &cobra.Command{
+		Use:   "tunnel",
+		Short: "Run a SOCKS5 and HTTP CONNECT proxy that reaches pods through port forwards",
+		Long: "Run a SOCKS5 and HTTP CONNECT proxy that reaches pods through port forwards.\n\n" +
+			"Destinations can be pod IPs, pod-name.namespace or a pod name in the current namespace, " +
+			"e.g. curl --proxy socks5h://127.0.0.1:1080 http://my-pod-0.default:8080/",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			tunnelArgs, err := parseTunnelArgs(cmd)
+			if err != nil {
+				return err
+			}
+			kubeClient, err := kube.GetClientUsingFlags(cmd)
+			if err != nil {
+				return err
+			}
+			return runTunnel(cmd.Context(), kubeClient, tunnelArgs)
+		},
+	}
+
+	tunnelCommand.Flags().String("listen", "127.0.0.1:1080", "The address to accept proxy connections on")
+	tunnelCommand.Flags().Duration("connect-timeout", 0, "The maximum time to spend finding and connecting to a pod (0 for no timeout)")
+	tunnelCommand.Flags().String("portforward-protocol", string(kube.PortForwardAuto), "How port forwards connect to the API server: auto (websocket, falling back to spdy), websocket or spdy")
+
+	return tunnelCommand
+}
@@ -0,0 +1,40 @@
+package kube
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// GetDisruptionBudget finds the most restrictive PodDisruptionBudget covering
+// any of the pods, nil is returned if no budget covers them
+func GetDisruptionBudget(ctx context.Context, kubeClient *KubeClient, pods []corev1.Pod) (*policyv1.PodDisruptionBudget, error) {
+	budgets, err := kubeClient.Client.PolicyV1().PodDisruptionBudgets(kubeClient.Namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	var strictest *policyv1.PodDisruptionBudget
+	for i := range budgets.Items {
+		budget := &budgets.Items[i]
+		// An empty selector covers every pod in the namespace, a missing one
+		// covers none
+		selector, err := metav1.LabelSelectorAsSelector(budget.Spec.Selector)
+		if err != nil {
+			continue
+		}
+		for _, pod := range pods {
+			if !selector.Matches(labels.Set(pod.Labels)) {
+				continue
+			}
+			if strictest == nil || budget.Status.DisruptionsAllowed < strictest.Status.DisruptionsAllowed {
+				strictest = budget
+			}
+			break
+		}
+	}
+	return strictest, nil
+}
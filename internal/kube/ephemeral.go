@@ -0,0 +1,106 @@
+package kube
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+const debugContainerPollInterval = 500 * time.Millisecond
+
+// Ephemeral containers can never be restarted or removed, so the container
+// just has to outlive anyone using it
+var debugContainerCommand = []string{"sleep", "2147483647"}
+
+// Reasons a container can be waiting that will not resolve themselves
+var debugContainerFailures = []string{"ErrImagePull", "ImagePullBackOff", "InvalidImageName", "ErrImageNeverPull", "CreateContainerError", "CreateContainerConfigError"}
+
+// debugContainerPrefix names debug containers after their image, so later
+// runs with the same image find and reuse the container
+func debugContainerPrefix(image string) string {
+	sum := sha256.Sum256([]byte(image))
+	return fmt.Sprintf("flotilla-%x", sum[:4])
+}
+
+func ephemeralContainerStatus(pod *v1.Pod, name string) *v1.ContainerStatus {
+	for i := range pod.Status.EphemeralContainerStatuses {
+		if pod.Status.EphemeralContainerStatuses[i].Name == name {
+			return &pod.Status.EphemeralContainerStatuses[i]
+		}
+	}
+	return nil
+}
+
+// EnsureDebugContainer returns a running ephemeral container with the image in
+// the pod's network namespace, adding one through the pods/ephemeralcontainers
+// subresource if there is not one already
+func EnsureDebugContainer(ctx context.Context, kubeClient *KubeClient, pod *v1.Pod, image string) (string, error) {
+	pods := kubeClient.Client.CoreV1().Pods(pod.Namespace)
+	current, err := pods.Get(ctx, pod.Name, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+
+	prefix := debugContainerPrefix(image)
+	existing := 0
+	for _, container := range current.Spec.EphemeralContainers {
+		if !strings.HasPrefix(container.Name, prefix) {
+			continue
+		}
+		existing++
+		status := ephemeralContainerStatus(current, container.Name)
+		if status != nil && status.State.Terminated != nil {
+			continue
+		}
+		return container.Name, waitForDebugContainer(ctx, kubeClient, pod, container.Name)
+	}
+
+	name := fmt.Sprintf("%s-%d", prefix, existing+1)
+	current.Spec.EphemeralContainers = append(current.Spec.EphemeralContainers, v1.EphemeralContainer{
+		EphemeralContainerCommon: v1.EphemeralContainerCommon{
+			Name:                     name,
+			Image:                    image,
+			Command:                  debugContainerCommand,
+			ImagePullPolicy:          v1.PullIfNotPresent,
+			TerminationMessagePolicy: v1.TerminationMessageReadFile,
+		},
+	})
+	if _, err := pods.UpdateEphemeralContainers(ctx, pod.Name, current, metav1.UpdateOptions{}); err != nil {
+		return "", fmt.Errorf("Unable to add debug container to %s: %w", pod.Name, err)
+	}
+	return name, waitForDebugContainer(ctx, kubeClient, pod, name)
+}
+
+func waitForDebugContainer(ctx context.Context, kubeClient *KubeClient, pod *v1.Pod, name string) error {
+	pods := kubeClient.Client.CoreV1().Pods(pod.Namespace)
+	return wait.PollUntilContextCancel(ctx, debugContainerPollInterval, true, func(ctx context.Context) (bool, error) {
+		current, err := pods.Get(ctx, pod.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		status := ephemeralContainerStatus(current, name)
+		switch {
+		case status == nil:
+			return false, nil
+		case status.State.Running != nil:
+			return true, nil
+		case status.State.Terminated != nil:
+			terminated := status.State.Terminated
+			return false, fmt.Errorf("Debug container %s exited: %s %s", name, terminated.Reason, terminated.Message)
+		case status.State.Waiting != nil:
+			waiting := status.State.Waiting
+			for _, failure := range debugContainerFailures {
+				if waiting.Reason == failure {
+					return false, fmt.Errorf("Debug container %s cannot start: %s %s", name, waiting.Reason, waiting.Message)
+				}
+			}
+		}
+		return false, nil
+	})
+}
@@ -0,0 +1,80 @@
+package kube
+
+import (
+	"context"
+	"io"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// ContainerForPort picks the container that declares the port, falling back
+// to the first container when none of them declare it
+func ContainerForPort(pod *v1.Pod, port uint16) string {
+	for _, container := range pod.Spec.Containers {
+		for _, containerPort := range container.Ports {
+			if containerPort.ContainerPort == int32(port) {
+				return container.Name
+			}
+		}
+	}
+	if len(pod.Spec.Containers) == 0 {
+		return ""
+	}
+	return pod.Spec.Containers[0].Name
+}
+
+// Exec runs a command in a container of the pod through the pods/exec
+// subresource, stdin is only attached when it is not nil
+func Exec(ctx context.Context, kubeClient *KubeClient, pod *v1.Pod, container string, command []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) error {
+	execRequest := kubeClient.Client.
+		CoreV1().
+		RESTClient().
+		Post().
+		Resource("pods").
+		Namespace(pod.Namespace).
+		Name(pod.Name).
+		SubResource("exec").
+		VersionedParams(&v1.PodExecOptions{
+			Container: container,
+			Command:   command,
+			Stdin:     stdin != nil,
+			Stdout:    true,
+			Stderr:    true,
+		}, scheme.ParameterCodec)
+
+	spdyExecutor, err := remotecommand.NewSPDYExecutor(kubeClient.Config, "POST", execRequest.URL())
+	if err != nil {
+		return err
+	}
+	websocketExecutor, err := remotecommand.NewWebSocketExecutor(kubeClient.Config, "GET", execRequest.URL().String())
+	if err != nil {
+		return err
+	}
+	// Same as port forwarding, prefer websockets and fall back to spdy
+	executor, err := remotecommand.NewFallbackExecutor(websocketExecutor, spdyExecutor, func(err error) bool {
+		return httpstream.IsUpgradeFailure(err) || httpstream.IsHTTPSProxyError(err)
+	})
+	if err != nil {
+		return err
+	}
+	return executor.StreamWithContext(ctx, remotecommand.StreamOptions{
+		Stdin:  stdin,
+		Stdout: stdout,
+		Stderr: stderr,
+	})
+}
+
+// IsExecutableNotFound reports whether an exec failed because the command
+// does not exist in the container, the runtimes word this differently
+func IsExecutableNotFound(err error, stderr string) bool {
+	for _, message := range []string{err.Error(), stderr} {
+		if strings.Contains(message, "executable file not found") || strings.Contains(message, "no such file or directory") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,111 @@
+package kube
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+)
+
+// PodEvents receives pods as they become Ready and as they are deleted,
+// OnReady is called each time a pod transitions to Ready and the optional
+// OnUnready when a Ready pod stops being Ready or starts terminating
+type PodEvents struct {
+	OnReady   func(*corev1.Pod)
+	OnUnready func(*corev1.Pod)
+	OnGone    func(*corev1.Pod)
+}
+
+func IsPodReady(pod *corev1.Pod) bool {
+	if pod.Status.Phase != corev1.PodRunning {
+		return false
+	}
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// FollowPodsForService runs an informer over the pods selected by a service
+// until the context is cancelled, it returns once the initial set of pods has
+// been delivered
+func FollowPodsForService(ctx context.Context, kubeClient *KubeClient, service *corev1.Service, events PodEvents) error {
+	selector := labels.Set(service.Spec.Selector).AsSelector().String()
+	factory := informers.NewSharedInformerFactoryWithOptions(
+		kubeClient.Client,
+		0,
+		informers.WithNamespace(kubeClient.Namespace),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = selector
+		}),
+	)
+	podInformer := factory.Core().V1().Pods().Informer()
+
+	var mu sync.Mutex
+	readyPods := map[types.UID]bool{}
+	onChange := func(obj any) {
+		pod, ok := obj.(*corev1.Pod)
+		if !ok {
+			return
+		}
+		ready := IsPodReady(pod) && pod.DeletionTimestamp == nil
+		mu.Lock()
+		wasReady := readyPods[pod.UID]
+		if ready {
+			readyPods[pod.UID] = true
+		} else {
+			delete(readyPods, pod.UID)
+		}
+		mu.Unlock()
+
+		if ready && !wasReady {
+			events.OnReady(pod)
+		} else if !ready && wasReady && events.OnUnready != nil {
+			events.OnUnready(pod)
+		}
+	}
+
+	_, err := podInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: onChange,
+		UpdateFunc: func(_, newObj any) {
+			onChange(newObj)
+		},
+		DeleteFunc: func(obj any) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			pod, ok := obj.(*corev1.Pod)
+			if !ok {
+				return
+			}
+			mu.Lock()
+			delete(readyPods, pod.UID)
+			mu.Unlock()
+			events.OnGone(pod)
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	factory.Start(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), podInformer.HasSynced) {
+		// The sync only stops early when the context ends, it is only a
+		// timeout if that is why the context ended rather than a cancellation
+		cause := context.Cause(ctx)
+		if !errors.Is(cause, context.DeadlineExceeded) {
+			return cause
+		}
+		return fmt.Errorf("Timed out waiting for pods of service '%s' to sync: %w", service.Name, cause)
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package kube
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestFollowPodsForServiceReportsWhySyncStopped(t *testing.T) {
+	// The test API server cannot list pods in a namespace, so the informer
+	// never syncs and only returns once the context ends
+	kubeClient := newTestClient(t, "shop")
+	service := &v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "web"}, Spec: v1.ServiceSpec{Selector: map[string]string{"app": "web"}}}
+	events := PodEvents{OnReady: func(*v1.Pod) {}, OnGone: func(*v1.Pod) {}}
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	if err := FollowPodsForService(cancelled, kubeClient, service, events); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected a cancellation, got %v", err)
+	}
+
+	timedOut, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := FollowPodsForService(timedOut, kubeClient, service, events)
+	if !errors.Is(err, context.DeadlineExceeded) || !strings.Contains(err.Error(), "Timed out") {
+		t.Errorf("expected a sync timeout, got %v", err)
+	}
+}
@@ -48,6 +48,7 @@ type KubeClient struct {
 	Client     *kubernetes.Clientset
 	Namespace  string
 	Config     *rest.Config
+	dialers    *dialerCache
 }
 
 func buildOutOfClusterConfig(kubeconfigOverride string, contextOverride string) (*rest.Config, string, error) {
@@ -115,11 +116,16 @@ func GetClient(kubeconfigOverride string, context string, namespaceOverride stri
 		Client:     client,
 		Namespace:  namespace,
 		Config:     kcfg,
+		dialers:    newDialerCache(),
 	}, nil
 }
 
+func GetService(ctx context.Context, kubeClient *KubeClient, serviceName *string) (*corev1.Service, error) {
+	return kubeClient.Client.CoreV1().Services(kubeClient.Namespace).Get(ctx, *serviceName, metav1.GetOptions{})
+}
+
 func GetPodsForService(ctx context.Context, kubeClient *KubeClient, serviceName *string) (*corev1.PodList, *corev1.Service, error) {
-	service, err := kubeClient.Client.CoreV1().Services(kubeClient.Namespace).Get(ctx, *serviceName, metav1.GetOptions{})
+	service, err := GetService(ctx, kubeClient, serviceName)
 	if err != nil {
 		return nil, nil, err
 	}
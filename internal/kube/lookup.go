@@ -0,0 +1,47 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+)
+
+// FindPodByIP looks for a running pod with the IP, across every namespace
+// when allowed and in the client's namespace otherwise
+func FindPodByIP(ctx context.Context, kubeClient *KubeClient, ip net.IP) (*corev1.Pod, error) {
+	listOptions := metav1.ListOptions{FieldSelector: fields.OneTermEqualSelector("status.podIP", ip.String()).String()}
+	pods, err := kubeClient.Client.CoreV1().Pods(metav1.NamespaceAll).List(ctx, listOptions)
+	if apierrors.IsForbidden(err) {
+		pods, err = kubeClient.Client.CoreV1().Pods(kubeClient.Namespace).List(ctx, listOptions)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for i := range pods.Items {
+		if pods.Items[i].Status.Phase == corev1.PodRunning {
+			return &pods.Items[i], nil
+		}
+	}
+	return nil, fmt.Errorf("No running pod has the IP %s", ip)
+}
+
+// FindPod resolves a destination host to a pod, the host can be a pod IP, a
+// pod name in the form name.namespace (anything after the namespace is
+// ignored) or a bare name in the client's namespace
+func FindPod(ctx context.Context, kubeClient *KubeClient, host string) (*corev1.Pod, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return FindPodByIP(ctx, kubeClient, ip)
+	}
+	name, domain, found := strings.Cut(strings.TrimSuffix(host, "."), ".")
+	namespace, _, _ := strings.Cut(domain, ".")
+	if !found {
+		namespace = kubeClient.Namespace
+	}
+	return kubeClient.Client.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
+}
@@ -1,53 +1,356 @@
 package kube
 
 import (
+	"errors"
+	"fmt"
+	"io"
 	"net"
+	"os"
+	"sync"
 	"time"
 
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/util/httpstream"
 )
 
+// The kubelet writes to the error stream just before it closes the data
+// stream, so a failed read waits this long for the reason to arrive
+const errorStreamGrace = 250 * time.Millisecond
+
+const readBufferSize = 32 * 1024
+
+// TunnelError is a failure of the port forward itself rather than of the
+// request sent through it, e.g. nothing listening on the port inside the pod
+type TunnelError struct {
+	Pod  string
+	Port uint16
+	Err  error
+}
+
+func (err *TunnelError) Error() string {
+	return fmt.Sprintf("port forward to %s:%d failed: %s", err.Pod, err.Port, err.Err)
+}
+
+func (err *TunnelError) Unwrap() error {
+	return err.Err
+}
+
+// tunnelAddr is the local end of a connection, there is no local socket so
+// it names the port forward request the streams belong to instead
+type tunnelAddr string
+
+func (addr tunnelAddr) Network() string {
+	return "portforward"
+}
+
+func (addr tunnelAddr) String() string {
+	return string(addr)
+}
+
+// deadline is closed when it passes, a deadline can be moved or cleared at
+// any time (this is the same approach net.Pipe uses)
+type deadline struct {
+	mu     sync.Mutex
+	timer  *time.Timer
+	passed chan struct{}
+}
+
+func newDeadline() *deadline {
+	return &deadline{passed: make(chan struct{})}
+}
+
+func (d *deadline) set(t time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.timer != nil && !d.timer.Stop() {
+		// The timer already fired, passed is about to be (or already is) closed
+		<-d.passed
+	}
+	d.timer = nil
+
+	closed := isClosed(d.passed)
+	if t.IsZero() {
+		if closed {
+			d.passed = make(chan struct{})
+		}
+		return
+	}
+	if wait := time.Until(t); wait > 0 {
+		if closed {
+			d.passed = make(chan struct{})
+		}
+		passed := d.passed
+		d.timer = time.AfterFunc(wait, func() { close(passed) })
+		return
+	}
+	if !closed {
+		close(d.passed)
+	}
+}
+
+func (d *deadline) wait() chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.passed
+}
+
+func isClosed(c chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
+
+type readResult struct {
+	data []byte
+	err  error
+}
+
+type writeResult struct {
+	n   int
+	err error
+}
+
+// writeRequest carries its own result channel, so a write abandoned because
+// of a deadline cannot have its result picked up by the next write
+type writeRequest struct {
+	data   []byte
+	result chan writeResult
+}
+
+// PodConn is a net.Conn over a pair of port forward streams. Streams have no
+// notion of deadlines, so reads and writes happen in background goroutines
+// and the deadlines decide how long the caller waits for them
 type PodConn struct {
-	dataStream httpstream.Stream
-	pod        *v1.Pod
+	streamConn  httpstream.Connection
+	dataStream  httpstream.Stream
+	errorStream httpstream.Stream
+	pod         *v1.Pod
+	port        uint16
+	requestId   string
+
+	errorRead chan struct{}
+	tunnelErr error
+
+	readMu        sync.Mutex
+	unread        []byte
+	readErr       error
+	reads         chan readResult
+	readDeadline  *deadline
+	writeMu       sync.Mutex
+	writes        chan writeRequest
+	writeDeadline *deadline
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func newPodConn(streamConn httpstream.Connection, dataStream httpstream.Stream, errorStream httpstream.Stream, pod *v1.Pod, port uint16, requestId string) *PodConn {
+	conn := &PodConn{
+		streamConn:    streamConn,
+		dataStream:    dataStream,
+		errorStream:   errorStream,
+		pod:           pod,
+		port:          port,
+		requestId:     requestId,
+		errorRead:     make(chan struct{}),
+		reads:         make(chan readResult),
+		readDeadline:  newDeadline(),
+		writes:        make(chan writeRequest),
+		writeDeadline: newDeadline(),
+		closed:        make(chan struct{}),
+	}
+	go conn.readErrorStream()
+	go conn.readLoop()
+	go conn.writeLoop()
+	return conn
+}
+
+func (p *PodConn) readErrorStream() {
+	defer close(p.errorRead)
+	message, err := io.ReadAll(p.errorStream)
+	switch {
+	case err != nil:
+		p.tunnelErr = &TunnelError{Pod: p.pod.Name, Port: p.port, Err: fmt.Errorf("unable to read error stream: %w", err)}
+	case len(message) > 0:
+		p.tunnelErr = &TunnelError{Pod: p.pod.Name, Port: p.port, Err: errors.New(string(message))}
+	}
+}
+
+// tunnelError returns the reason the tunnel reported for a failure, if it
+// reports one in time
+func (p *PodConn) tunnelError() error {
+	select {
+	case <-p.errorRead:
+		return p.tunnelErr
+	case <-time.After(errorStreamGrace):
+		return nil
+	}
+}
+
+func (p *PodConn) readLoop() {
+	for {
+		buffer := make([]byte, readBufferSize)
+		n, err := p.dataStream.Read(buffer)
+		if err != nil {
+			if tunnelErr := p.tunnelError(); tunnelErr != nil {
+				err = tunnelErr
+			}
+		}
+		select {
+		case p.reads <- readResult{data: buffer[:n], err: err}:
+		case <-p.closed:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
 }
 
-func NewPodConn(pod *v1.Pod, stream httpstream.Stream) net.Conn {
-	return PodConn{
-		dataStream: stream,
-		pod:        pod,
+func (p *PodConn) writeLoop() {
+	for {
+		select {
+		case write := <-p.writes:
+			n, err := p.dataStream.Write(write.data)
+			if err != nil {
+				if tunnelErr := p.tunnelError(); tunnelErr != nil {
+					err = tunnelErr
+				}
+			}
+			write.result <- writeResult{n: n, err: err}
+		case <-p.closed:
+			return
+		}
 	}
 }
 
-func (p PodConn) Close() error {
+func (p *PodConn) opError(op string, err error) error {
+	return &net.OpError{Op: op, Net: "tcp", Source: p.LocalAddr(), Addr: p.RemoteAddr(), Err: err}
+}
+
+func (p *PodConn) Close() error {
+	err := net.ErrClosed
+	p.closeOnce.Do(func() {
+		close(p.closed)
+		// Resetting rather than closing the streams also ends the read side,
+		// which unblocks the read loop
+		err = p.dataStream.Reset()
+		p.errorStream.Reset()
+		p.streamConn.RemoveStreams(p.dataStream, p.errorStream)
+	})
+	return err
+}
+
+// CloseWrite tells the pod nothing more will be written, reads carry on until
+// the pod closes its side
+func (p *PodConn) CloseWrite() error {
+	p.writeMu.Lock()
+	defer p.writeMu.Unlock()
 	return p.dataStream.Close()
 }
 
-func (p PodConn) LocalAddr() net.Addr {
-	return nil
+func (p *PodConn) LocalAddr() net.Addr {
+	return tunnelAddr("portforward/" + p.requestId)
 }
 
-func (p PodConn) RemoteAddr() net.Addr {
-	return nil
+func (p *PodConn) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.ParseIP(p.pod.Status.PodIP), Port: int(p.port)}
 }
 
-func (p PodConn) Read(b []byte) (n int, err error) {
-	return p.dataStream.Read(b)
+func (p *PodConn) Read(b []byte) (n int, err error) {
+	p.readMu.Lock()
+	defer p.readMu.Unlock()
+
+	switch {
+	case isClosed(p.closed):
+		return 0, p.opError("read", net.ErrClosed)
+	case isClosed(p.readDeadline.wait()):
+		return 0, p.opError("read", os.ErrDeadlineExceeded)
+	}
+	if len(p.unread) == 0 && p.readErr == nil {
+		select {
+		case result := <-p.reads:
+			p.unread, p.readErr = result.data, result.err
+		case <-p.readDeadline.wait():
+			return 0, p.opError("read", os.ErrDeadlineExceeded)
+		case <-p.closed:
+			return 0, p.opError("read", net.ErrClosed)
+		}
+	}
+
+	n = copy(b, p.unread)
+	p.unread = p.unread[n:]
+	if len(p.unread) == 0 && p.readErr != nil {
+		// io.EOF is passed through as is, callers compare against it directly
+		if p.readErr == io.EOF {
+			return n, io.EOF
+		}
+		return n, p.opError("read", p.readErr)
+	}
+	return n, nil
 }
 
-func (p PodConn) Write(b []byte) (n int, err error) {
-	return p.dataStream.Write(b)
+func (p *PodConn) Write(b []byte) (n int, err error) {
+	p.writeMu.Lock()
+	defer p.writeMu.Unlock()
+
+	switch {
+	case isClosed(p.closed):
+		return 0, p.opError("write", net.ErrClosed)
+	case isClosed(p.writeDeadline.wait()):
+		return 0, p.opError("write", os.ErrDeadlineExceeded)
+	}
+	// The write can outlive this call if the deadline passes, so it gets its
+	// own copy of the data
+	write := writeRequest{
+		data:   make([]byte, len(b)),
+		result: make(chan writeResult, 1),
+	}
+	copy(write.data, b)
+	select {
+	case p.writes <- write:
+	case <-p.writeDeadline.wait():
+		return 0, p.opError("write", os.ErrDeadlineExceeded)
+	case <-p.closed:
+		return 0, p.opError("write", net.ErrClosed)
+	}
+	select {
+	case result := <-write.result:
+		if result.err != nil {
+			return result.n, p.opError("write", result.err)
+		}
+		return result.n, nil
+	case <-p.writeDeadline.wait():
+		return 0, p.opError("write", os.ErrDeadlineExceeded)
+	case <-p.closed:
+		return 0, p.opError("write", net.ErrClosed)
+	}
 }
 
-func (p PodConn) SetDeadline(t time.Time) error {
-	return p.SetDeadline(t)
+func (p *PodConn) SetDeadline(t time.Time) error {
+	if isClosed(p.closed) {
+		return p.opError("set", net.ErrClosed)
+	}
+	p.readDeadline.set(t)
+	p.writeDeadline.set(t)
+	return nil
 }
 
-func (p PodConn) SetReadDeadline(t time.Time) error {
-	return p.SetReadDeadline(t)
+func (p *PodConn) SetReadDeadline(t time.Time) error {
+	if isClosed(p.closed) {
+		return p.opError("set", net.ErrClosed)
+	}
+	p.readDeadline.set(t)
+	return nil
 }
 
-func (p PodConn) SetWriteDeadline(t time.Time) error {
-	return p.SetWriteDeadline(t)
+func (p *PodConn) SetWriteDeadline(t time.Time) error {
+	if isClosed(p.closed) {
+		return p.opError("set", net.ErrClosed)
+	}
+	p.writeDeadline.set(t)
+	return nil
 }
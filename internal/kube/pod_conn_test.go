@@ -0,0 +1,127 @@
+package kube
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/nettest"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+)
+
+// memoryStream is one end of an in-memory stream pair, Close ends our
+// direction like a half-closed SPDY stream and Reset ends both
+type memoryStream struct {
+	reader *io.PipeReader
+	writer *io.PipeWriter
+}
+
+func (stream *memoryStream) Read(b []byte) (int, error)  { return stream.reader.Read(b) }
+func (stream *memoryStream) Write(b []byte) (int, error) { return stream.writer.Write(b) }
+func (stream *memoryStream) Close() error                { return stream.writer.Close() }
+func (stream *memoryStream) Headers() http.Header        { return http.Header{} }
+func (stream *memoryStream) Identifier() uint32          { return 0 }
+
+// Reset matches spdystream, where the other end reads EOF from a reset stream
+func (stream *memoryStream) Reset() error {
+	stream.writer.Close()
+	stream.reader.Close()
+	return nil
+}
+
+func memoryStreamPair() (*memoryStream, *memoryStream) {
+	aReader, bWriter := io.Pipe()
+	bReader, aWriter := io.Pipe()
+	return &memoryStream{reader: aReader, writer: aWriter}, &memoryStream{reader: bReader, writer: bWriter}
+}
+
+// errorStream is what the kubelet sends on a port forward's error stream
+func errorStream(message string) httpstream.Stream {
+	local, remote := memoryStreamPair()
+	go func() {
+		io.WriteString(remote, message)
+		remote.Close()
+	}()
+	return local
+}
+
+type memoryConnection struct {
+	closed chan bool
+}
+
+func (conn *memoryConnection) CreateStream(http.Header) (httpstream.Stream, error) {
+	return nil, errors.New("not supported")
+}
+func (conn *memoryConnection) Close() error                       { return nil }
+func (conn *memoryConnection) CloseChan() <-chan bool             { return conn.closed }
+func (conn *memoryConnection) SetIdleTimeout(time.Duration)       {}
+func (conn *memoryConnection) RemoveStreams(...httpstream.Stream) {}
+
+func testPod() *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod-0"},
+		Status:     v1.PodStatus{PodIP: "10.0.0.1"},
+	}
+}
+
+func newMemoryPodConn(dataStream httpstream.Stream, errorStream httpstream.Stream) *PodConn {
+	return newPodConn(&memoryConnection{closed: make(chan bool)}, dataStream, errorStream, testPod(), 8080, "1")
+}
+
+func TestPodConnConformance(t *testing.T) {
+	nettest.TestConn(t, func() (net.Conn, net.Conn, func(), error) {
+		local, remote := memoryStreamPair()
+		c1 := newMemoryPodConn(local, errorStream(""))
+		c2 := newMemoryPodConn(remote, errorStream(""))
+		stop := func() {
+			c1.Close()
+			c2.Close()
+		}
+		return c1, c2, stop, nil
+	})
+}
+
+func TestPodConnReportsTunnelErrors(t *testing.T) {
+	local, remote := memoryStreamPair()
+	// The kubelet reports the failure then closes the data stream
+	message := "error forwarding port 8080 to pod: connection refused"
+	conn := newMemoryPodConn(local, errorStream(message))
+	defer conn.Close()
+	remote.Reset()
+
+	_, err := conn.Read(make([]byte, 1))
+	var tunnelErr *TunnelError
+	if !errors.As(err, &tunnelErr) {
+		t.Fatalf("expected a *TunnelError, got %T: %v", err, err)
+	}
+	if tunnelErr.Pod != "pod-0" || tunnelErr.Port != 8080 {
+		t.Errorf("expected the error for pod-0:8080, got %s:%d", tunnelErr.Pod, tunnelErr.Port)
+	}
+	if !strings.Contains(err.Error(), message) {
+		t.Errorf("expected %q to contain %q", err, message)
+	}
+}
+
+func TestPodConnReadsEOFWithoutTunnelError(t *testing.T) {
+	local, remote := memoryStreamPair()
+	conn := newMemoryPodConn(local, errorStream(""))
+	defer conn.Close()
+	go func() {
+		io.WriteString(remote, "done")
+		remote.Close()
+	}()
+
+	data, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("expected a clean EOF, got %v", err)
+	}
+	if string(data) != "done" {
+		t.Errorf("expected %q, got %q", "done", data)
+	}
+}
@@ -1,8 +1,13 @@
 package kube
 
 import (
+	"context"
 	"fmt"
+	"net"
 	"net/http"
+	"net/url"
+	"slices"
+	"sync"
 
 	"github.com/google/uuid"
 	v1 "k8s.io/api/core/v1"
@@ -11,84 +16,200 @@ import (
 	"k8s.io/client-go/transport/spdy"
 )
 
-func createDialer(kubeClient *KubeClient, podName *string) (httpstream.Dialer, error) {
-	portforwardRequest := kubeClient.Client.
+// PortForwardProtocol picks how the port forward connection is upgraded,
+// auto tries websockets first and falls back to SPDY
+type PortForwardProtocol string
+
+const (
+	PortForwardAuto      PortForwardProtocol = "auto"
+	PortForwardWebsocket PortForwardProtocol = "websocket"
+	PortForwardSPDY      PortForwardProtocol = "spdy"
+)
+
+var PortForwardProtocols = []PortForwardProtocol{PortForwardAuto, PortForwardWebsocket, PortForwardSPDY}
+
+func ParsePortForwardProtocol(protocol string) (PortForwardProtocol, error) {
+	if !slices.Contains(PortForwardProtocols, PortForwardProtocol(protocol)) {
+		return "", fmt.Errorf("Unknown port forward protocol '%s', expected auto, websocket or spdy", protocol)
+	}
+	return PortForwardProtocol(protocol), nil
+}
+
+// dialerCache holds the parts of a port forward that can be shared between
+// requests, the SPDY round tripper only depends on the cluster config and a
+// dialer can be reused for every connection to the same pod
+type dialerCache struct {
+	mu        sync.Mutex
+	transport http.RoundTripper
+	upgrader  spdy.Upgrader
+	dialers   map[string]httpstream.Dialer
+}
+
+func newDialerCache() *dialerCache {
+	return &dialerCache{dialers: map[string]httpstream.Dialer{}}
+}
+
+// Pods are looked up across namespaces so the same name can be in use by
+// more than one of them
+func dialerKey(pod *v1.Pod, protocol PortForwardProtocol) string {
+	return pod.Namespace + "/" + pod.Name + "/" + string(protocol)
+}
+
+// forgetDialers drops the pod's dialers, for pods that have gone away
+func forgetDialers(kubeClient *KubeClient, pod *v1.Pod) {
+	cache := kubeClient.dialers
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	for _, protocol := range PortForwardProtocols {
+		delete(cache.dialers, dialerKey(pod, protocol))
+	}
+}
+
+func portForwardURL(kubeClient *KubeClient, pod *v1.Pod) *url.URL {
+	return kubeClient.Client.
 		CoreV1().
 		RESTClient().
 		Post().
 		Resource("pods").
-		Namespace(kubeClient.Namespace).
-		Name(*podName).
-		SubResource("portforward")
+		Namespace(pod.Namespace).
+		Name(pod.Name).
+		SubResource("portforward").
+		URL()
+}
 
-	transport, upgrader, err := spdy.RoundTripperFor(kubeClient.Config)
-	if err != nil {
-		return nil, err
+func createDialer(kubeClient *KubeClient, pod *v1.Pod, protocol PortForwardProtocol) (httpstream.Dialer, error) {
+	cache := kubeClient.dialers
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	key := dialerKey(pod, protocol)
+	if dialer, ok := cache.dialers[key]; ok {
+		return dialer, nil
 	}
 
-	spdyDialer := spdy.NewDialer(upgrader, &http.Client{Transport: transport}, "POST", portforwardRequest.URL())
-	tunnelingDialer, err := portforward.NewSPDYOverWebsocketDialer(portforwardRequest.URL(), kubeClient.Config)
-	if err != nil {
-		return nil, err
+	portforwardURL := portForwardURL(kubeClient, pod)
+
+	if cache.transport == nil {
+		transport, upgrader, err := spdy.RoundTripperFor(kubeClient.Config)
+		if err != nil {
+			return nil, err
+		}
+		cache.transport, cache.upgrader = transport, upgrader
 	}
-	// First attempt tunneling (websocket) dialer, then fallback to spdy dialer.
-	dialer := portforward.NewFallbackDialer(tunnelingDialer, spdyDialer, func(err error) bool {
-		return httpstream.IsUpgradeFailure(err) || httpstream.IsHTTPSProxyError(err)
-	})
+
+	var dialer httpstream.Dialer = spdy.NewDialer(cache.upgrader, &http.Client{Transport: cache.transport}, "POST", portforwardURL)
+	if protocol != PortForwardSPDY {
+		tunnelingDialer, err := portforward.NewSPDYOverWebsocketDialer(portforwardURL, kubeClient.Config)
+		if err != nil {
+			return nil, err
+		}
+		if protocol == PortForwardWebsocket {
+			dialer = tunnelingDialer
+		} else {
+			// First attempt tunneling (websocket) dialer, then fallback to spdy dialer.
+			dialer = portforward.NewFallbackDialer(tunnelingDialer, dialer, func(err error) bool {
+				return httpstream.IsUpgradeFailure(err) || httpstream.IsHTTPSProxyError(err)
+			})
+		}
+	}
+	cache.dialers[key] = dialer
 	return dialer, nil
 }
 
+// PortTunnel is a single upgraded connection to a pod's port forward, every
+// call to Dial opens a new pair of streams over it so it can carry any number
+// of connections to the pod
 type PortTunnel struct {
-	ErrorStream httpstream.Stream
-	DataStream  httpstream.Stream
-	StreamConn  httpstream.Connection
+	StreamConn httpstream.Connection
+	pod        *v1.Pod
+	port       uint16
 }
 
-func PortForward(kubeClient *KubeClient, pod *v1.Pod, port uint16) (*PortTunnel, error) {
-	requestId := uuid.New().String()
-	dialer, err := createDialer(kubeClient, &pod.Name)
+// The dialers provided by client-go do not accept a context, so we dial in the
+// background and abandon (and clean up) the connection if the context ends first
+func dialWithContext(ctx context.Context, dialer httpstream.Dialer, protocols ...string) (httpstream.Connection, string, error) {
+	type dialResult struct {
+		conn     httpstream.Connection
+		protocol string
+		err      error
+	}
+	results := make(chan dialResult, 1)
+	go func() {
+		conn, protocol, err := dialer.Dial(protocols...)
+		results <- dialResult{conn, protocol, err}
+	}()
+
+	select {
+	case result := <-results:
+		return result.conn, result.protocol, result.err
+	case <-ctx.Done():
+		go func() {
+			if result := <-results; result.conn != nil {
+				result.conn.Close()
+			}
+		}()
+		return nil, "", ctx.Err()
+	}
+}
+
+func PortForward(ctx context.Context, kubeClient *KubeClient, pod *v1.Pod, port uint16, protocol PortForwardProtocol) (*PortTunnel, error) {
+	dialer, err := createDialer(kubeClient, pod, protocol)
 	if err != nil {
-		return nil, err
+		return nil, &TunnelError{Pod: pod.Name, Port: port, Err: err}
 	}
 
-	streamConn, protocol, err := dialer.Dial(portforward.PortForwardProtocolV1Name)
+	streamConn, streamProtocol, err := dialWithContext(ctx, dialer, portforward.PortForwardProtocolV1Name)
 	if err != nil {
-		return nil, err
+		return nil, &TunnelError{Pod: pod.Name, Port: port, Err: err}
 	}
+	if streamProtocol != portforward.PortForwardProtocolV1Name {
+		streamConn.Close()
+		return nil, &TunnelError{Pod: pod.Name, Port: port, Err: fmt.Errorf("Server responded with incorrect protocol: %q", streamProtocol)}
+	}
+
+	return &PortTunnel{
+		StreamConn: streamConn,
+		pod:        pod,
+		port:       port,
+	}, nil
+}
 
+// Dial opens a connection to the pod's port, the streams are removed from the
+// tunnel when the connection is closed and anything the pod reports on the
+// error stream is returned from the connection as a TunnelError
+func (tunnel *PortTunnel) Dial() (net.Conn, error) {
+	requestId := uuid.New().String()
 	headers := http.Header{}
 	headers.Set(v1.StreamType, v1.StreamTypeError)
-	headers.Set(v1.PortHeader, fmt.Sprintf("%d", port))
+	headers.Set(v1.PortHeader, fmt.Sprintf("%d", tunnel.port))
 	headers.Set(v1.PortForwardRequestIDHeader, requestId)
-	errorStream, err := streamConn.CreateStream(headers)
-	if protocol != portforward.PortForwardProtocolV1Name {
-		return nil, fmt.Errorf("Server responded with incorrect protocol: %q", protocol)
+	errorStream, err := tunnel.StreamConn.CreateStream(headers)
+	if err != nil {
+		return nil, &TunnelError{Pod: tunnel.pod.Name, Port: tunnel.port, Err: err}
 	}
 	// We can close as only used for reading
 	errorStream.Close()
-	// TODO: Should probs handle error channel in a goroutine or smthng
 
 	headers.Set(v1.StreamType, v1.StreamTypeData)
-	dataStream, err := streamConn.CreateStream(headers)
+	dataStream, err := tunnel.StreamConn.CreateStream(headers)
 	if err != nil {
-		errorStream.Close()
-		streamConn.RemoveStreams(errorStream)
-		return nil, err
+		tunnel.StreamConn.RemoveStreams(errorStream)
+		return nil, &TunnelError{Pod: tunnel.pod.Name, Port: tunnel.port, Err: err}
 	}
 
-	return &PortTunnel{
-		ErrorStream: errorStream,
-		DataStream:  dataStream,
-		StreamConn:  streamConn,
-	}, nil
+	return newPodConn(tunnel.StreamConn, dataStream, errorStream, tunnel.pod, tunnel.port, requestId), nil
 }
 
-func (openPort *PortTunnel) Close() {
-	openPort.DataStream.Close()
-	openPort.StreamConn.RemoveStreams(openPort.DataStream)
-
-	openPort.ErrorStream.Close()
-	openPort.StreamConn.RemoveStreams(openPort.ErrorStream)
+// Closed reports whether the underlying connection has gone away, at which
+// point the tunnel cannot be dialed again
+func (tunnel *PortTunnel) Closed() bool {
+	select {
+	case <-tunnel.StreamConn.CloseChan():
+		return true
+	default:
+		return false
+	}
+}
 
-	openPort.StreamConn.Close()
+func (tunnel *PortTunnel) Close() {
+	tunnel.StreamConn.Close()
 }
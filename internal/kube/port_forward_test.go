@@ -0,0 +1,78 @@
+package kube
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// newTestClient is a client for an API server that only lists pods across
+// every namespace, the selector is ignored so every list returns all the pods
+func newTestClient(t *testing.T, namespace string, pods ...v1.Pod) *KubeClient {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.Path != "/api/v1/pods" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(v1.PodList{
+			TypeMeta: metav1.TypeMeta{Kind: "PodList", APIVersion: "v1"},
+			Items:    pods,
+		})
+	}))
+	t.Cleanup(server.Close)
+
+	config := &rest.Config{Host: server.URL}
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &KubeClient{Client: client, Namespace: namespace, Config: config, dialers: newDialerCache()}
+}
+
+func namespacedPod(namespace string, name string, ip string) v1.Pod {
+	return v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Status:     v1.PodStatus{Phase: v1.PodRunning, PodIP: ip},
+	}
+}
+
+func TestPortForwardURLUsesPodNamespace(t *testing.T) {
+	kubeClient := newTestClient(t, "default", namespacedPod("payments", "api-0", "10.0.0.9"))
+
+	pod, err := FindPod(context.Background(), kubeClient, "10.0.0.9")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "/api/v1/namespaces/payments/pods/api-0/portforward"; portForwardURL(kubeClient, pod).Path != want {
+		t.Errorf("expected %s, got %s", want, portForwardURL(kubeClient, pod).Path)
+	}
+}
+
+func TestDialersAreKeptPerNamespace(t *testing.T) {
+	kubeClient := newTestClient(t, "default")
+	podA := namespacedPod("ns-a", "api-0", "10.0.0.1")
+	podB := namespacedPod("ns-b", "api-0", "10.0.0.2")
+
+	for _, pod := range []*v1.Pod{&podA, &podB} {
+		if _, err := createDialer(kubeClient, pod, PortForwardSPDY); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(kubeClient.dialers.dialers) != 2 {
+		t.Fatalf("expected a dialer per namespace, got %d", len(kubeClient.dialers.dialers))
+	}
+
+	forgetDialers(kubeClient, &podA)
+	if _, ok := kubeClient.dialers.dialers[dialerKey(&podB, PortForwardSPDY)]; !ok || len(kubeClient.dialers.dialers) != 1 {
+		t.Errorf("expected only ns-a's dialer to be forgotten, got %v", kubeClient.dialers.dialers)
+	}
+}
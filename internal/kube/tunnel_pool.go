@@ -0,0 +1,277 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	reconnectBackoff     = 500 * time.Millisecond
+	maxReconnectBackoff  = 30 * time.Second
+	maxReconnectAttempts = 12
+)
+
+type TunnelEventType int
+
+const (
+	// TunnelLost is sent when an open tunnel closes or stops accepting streams
+	TunnelLost TunnelEventType = iota
+	// TunnelReconnecting is sent before each reconnection attempt
+	TunnelReconnecting
+	TunnelReconnected
+	// TunnelGaveUp is sent once reconnecting in the background stops, the next
+	// Get still dials a new tunnel
+	TunnelGaveUp
+)
+
+// TunnelEvent reports a pooled tunnel dropping and being reconnected
+type TunnelEvent struct {
+	Type    TunnelEventType
+	Pod     *v1.Pod
+	Port    uint16
+	Attempt int
+	Delay   time.Duration
+	Err     error
+}
+
+func (event TunnelEvent) String() string {
+	target := fmt.Sprintf("%s:%d", event.Pod.Name, event.Port)
+	switch event.Type {
+	case TunnelLost:
+		if event.Err != nil {
+			return fmt.Sprintf("tunnel to %s lost: %s", target, event.Err)
+		}
+		return fmt.Sprintf("tunnel to %s lost", target)
+	case TunnelReconnecting:
+		return fmt.Sprintf("tunnel to %s reconnecting in %s (attempt %d)", target, event.Delay.Round(time.Millisecond), event.Attempt)
+	case TunnelReconnected:
+		return fmt.Sprintf("tunnel to %s reconnected after %d attempts", target, event.Attempt)
+	case TunnelGaveUp:
+		return fmt.Sprintf("tunnel to %s gave up reconnecting after %d attempts: %s", target, event.Attempt, event.Err)
+	}
+	return target
+}
+
+type pooledTunnel struct {
+	mu           sync.Mutex
+	tunnel       *PortTunnel
+	reconnecting bool
+	removed      bool
+}
+
+// TunnelPool keeps one PortTunnel open per pod so repeated requests reuse the
+// same upgraded connection. The connection is kept alive with pings by
+// client-go, when it drops anyway (e.g. the API server restarts) it is
+// reconnected in the background with backoff, and any Get in the meantime
+// dials straight away
+type TunnelPool struct {
+	kubeClient *KubeClient
+	port       uint16
+	protocol   PortForwardProtocol
+	mu         sync.Mutex
+	tunnels    map[types.UID]*pooledTunnel
+	onEvent    func(TunnelEvent)
+	ctx        context.Context
+	cancel     context.CancelFunc
+}
+
+func NewTunnelPool(kubeClient *KubeClient, port uint16, protocol PortForwardProtocol) *TunnelPool {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &TunnelPool{
+		kubeClient: kubeClient,
+		port:       port,
+		protocol:   protocol,
+		tunnels:    map[types.UID]*pooledTunnel{},
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+}
+
+// OnEvent registers a callback for tunnels dropping and reconnecting, it is
+// called from background goroutines
+func (pool *TunnelPool) OnEvent(onEvent func(TunnelEvent)) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	pool.onEvent = onEvent
+}
+
+func (pool *TunnelPool) notify(event TunnelEvent) {
+	pool.mu.Lock()
+	onEvent := pool.onEvent
+	pool.mu.Unlock()
+	event.Port = pool.port
+	if onEvent != nil {
+		onEvent(event)
+	}
+}
+
+func (pool *TunnelPool) entry(pod *v1.Pod) *pooledTunnel {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	entry, ok := pool.tunnels[pod.UID]
+	if !ok {
+		entry = &pooledTunnel{}
+		pool.tunnels[pod.UID] = entry
+	}
+	return entry
+}
+
+// dial replaces the entry's tunnel, the entry must be locked
+func (pool *TunnelPool) dial(ctx context.Context, entry *pooledTunnel, pod *v1.Pod) (*PortTunnel, error) {
+	tunnel, err := PortForward(ctx, pool.kubeClient, pod, pool.port, pool.protocol)
+	if err != nil {
+		return nil, err
+	}
+	entry.tunnel = tunnel
+	go pool.monitor(entry, pod, tunnel)
+	return tunnel, nil
+}
+
+// Get returns the open tunnel for a pod, only one caller dials a pod at a time
+// so concurrent requests to a new pod share a single tunnel
+func (pool *TunnelPool) Get(ctx context.Context, pod *v1.Pod) (*PortTunnel, error) {
+	entry := pool.entry(pod)
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	if entry.tunnel != nil && !entry.tunnel.Closed() {
+		return entry.tunnel, nil
+	}
+	return pool.dial(ctx, entry, pod)
+}
+
+// monitor waits for a tunnel to close, tunnels that are still in use when
+// they close have been lost rather than replaced or closed by us
+func (pool *TunnelPool) monitor(entry *pooledTunnel, pod *v1.Pod, tunnel *PortTunnel) {
+	select {
+	case <-tunnel.StreamConn.CloseChan():
+	case <-pool.ctx.Done():
+		return
+	}
+	entry.mu.Lock()
+	lost := entry.tunnel == tunnel
+	if lost {
+		entry.tunnel = nil
+	}
+	entry.mu.Unlock()
+	if lost {
+		pool.lost(entry, pod, nil)
+	}
+}
+
+// lost reports a tunnel that dropped and reconnects it in the background,
+// unless that is already happening
+func (pool *TunnelPool) lost(entry *pooledTunnel, pod *v1.Pod, err error) {
+	pool.notify(TunnelEvent{Type: TunnelLost, Pod: pod, Err: err})
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	if entry.reconnecting || entry.removed {
+		return
+	}
+	entry.reconnecting = true
+	go pool.reconnect(entry, pod)
+}
+
+func reconnectDelay(attempt int) time.Duration {
+	backoff := min(reconnectBackoff<<min(attempt-1, 16), maxReconnectBackoff)
+	// Equal jitter, so pods that dropped together do not reconnect together
+	return backoff/2 + rand.N(backoff/2+1)
+}
+
+func (pool *TunnelPool) reconnect(entry *pooledTunnel, pod *v1.Pod) {
+	defer func() {
+		entry.mu.Lock()
+		entry.reconnecting = false
+		entry.mu.Unlock()
+	}()
+
+	var err error
+	for attempt := 1; attempt <= maxReconnectAttempts; attempt++ {
+		delay := reconnectDelay(attempt)
+		pool.notify(TunnelEvent{Type: TunnelReconnecting, Pod: pod, Attempt: attempt, Delay: delay})
+		select {
+		case <-time.After(delay):
+		case <-pool.ctx.Done():
+			return
+		}
+
+		err = nil
+		entry.mu.Lock()
+		if entry.removed {
+			entry.mu.Unlock()
+			return
+		}
+		// Someone may have needed the tunnel and dialed it in the meantime
+		if entry.tunnel == nil || entry.tunnel.Closed() {
+			dialCtx, cancel := context.WithTimeout(pool.ctx, maxReconnectBackoff)
+			_, err = pool.dial(dialCtx, entry, pod)
+			cancel()
+		}
+		entry.mu.Unlock()
+		if pool.ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			pool.notify(TunnelEvent{Type: TunnelReconnected, Pod: pod, Attempt: attempt})
+			return
+		}
+	}
+	pool.notify(TunnelEvent{Type: TunnelGaveUp, Pod: pod, Attempt: maxReconnectAttempts, Err: err})
+}
+
+// Evict closes a tunnel that is no longer usable, so the next Get dials a new
+// one, tunnels that have already been replaced are left alone
+func (pool *TunnelPool) Evict(pod *v1.Pod, tunnel *PortTunnel, err error) {
+	entry := pool.entry(pod)
+	entry.mu.Lock()
+	lost := entry.tunnel == tunnel
+	if lost {
+		entry.tunnel = nil
+	}
+	entry.mu.Unlock()
+	tunnel.Close()
+	if lost {
+		pool.lost(entry, pod, err)
+	}
+}
+
+// Remove closes the pod's tunnel and forgets the pod, for pods that have gone
+// away. Any reconnection in the background stops at its next attempt
+func (pool *TunnelPool) Remove(pod *v1.Pod) {
+	pool.mu.Lock()
+	entry, ok := pool.tunnels[pod.UID]
+	delete(pool.tunnels, pod.UID)
+	pool.mu.Unlock()
+	forgetDialers(pool.kubeClient, pod)
+	if !ok {
+		return
+	}
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	entry.removed = true
+	if entry.tunnel != nil {
+		entry.tunnel.Close()
+		entry.tunnel = nil
+	}
+}
+
+func (pool *TunnelPool) Close() {
+	pool.cancel()
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	for uid, entry := range pool.tunnels {
+		entry.mu.Lock()
+		entry.removed = true
+		if entry.tunnel != nil {
+			entry.tunnel.Close()
+			entry.tunnel = nil
+		}
+		entry.mu.Unlock()
+		delete(pool.tunnels, uid)
+	}
+}
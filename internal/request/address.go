@@ -0,0 +1,88 @@
+package request
+
+import (
+	"fmt"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// AddressMode picks the host requests to a pod are sent to, which is also
+// the Host header and the name TLS certificates are checked against
+type AddressMode string
+
+const (
+	// AddressIP sends requests to the pod IP
+	AddressIP AddressMode = "ip"
+	// AddressPodDNS uses the pod's A record, e.g. 10-0-0-1.ns.pod.cluster.local
+	AddressPodDNS AddressMode = "pod-dns"
+	// AddressHostname uses the pod's hostname under its subdomain, e.g.
+	// pod-0.svc.ns.svc.cluster.local, or the dashed IP the headless service
+	// gives pods without one, e.g. 10-0-0-1.svc.ns.svc.cluster.local
+	AddressHostname AddressMode = "hostname"
+)
+
+var addressModes = []AddressMode{AddressIP, AddressPodDNS, AddressHostname}
+
+const DefaultClusterDomain = "cluster.local"
+
+// PodAddressing decides which of a pod's addresses are requested and how each
+// one is named
+type PodAddressing struct {
+	Family        IPFamily
+	Mode          AddressMode
+	ClusterDomain string
+	// Service is the subdomain for pods that do not set one, which only
+	// resolves when the service is headless
+	Service *v1.Service
+}
+
+func (args *Args) Addressing(service *v1.Service) *PodAddressing {
+	return &PodAddressing{
+		Family:        args.IPFamily,
+		Mode:          args.AddressMode,
+		ClusterDomain: args.ClusterDomain,
+		Service:       service,
+	}
+}
+
+// dashedIP is an address as it appears in DNS names, e.g. 10-0-0-1
+func dashedIP(ip string) string {
+	return strings.NewReplacer(".", "-", ":", "-").Replace(ip)
+}
+
+// Host names one of the pod's addresses, an empty address is only allowed
+// when the pod is addressed by IP
+func (addressing *PodAddressing) Host(pod *v1.Pod, ip string) (string, error) {
+	switch addressing.Mode {
+	case AddressPodDNS:
+		if ip == "" {
+			return "", fmt.Errorf("Pod '%s' has %w for its DNS name", pod.Name, errNoPodIP)
+		}
+		return dashedIP(ip) + "." + pod.Namespace + ".pod." + addressing.ClusterDomain, nil
+	case AddressHostname:
+		// Only pods setting both get a record for their hostname
+		if pod.Spec.Hostname != "" && pod.Spec.Subdomain != "" {
+			return pod.Spec.Hostname + "." + pod.Spec.Subdomain + "." + pod.Namespace + ".svc." + addressing.ClusterDomain, nil
+		}
+		if addressing.Service == nil || addressing.Service.Spec.ClusterIP != v1.ClusterIPNone {
+			return "", fmt.Errorf("Pod '%s' has no hostname and subdomain and there is no headless service to use instead", pod.Name)
+		}
+		if ip == "" {
+			return "", fmt.Errorf("Pod '%s' has %w for its headless service record", pod.Name, errNoPodIP)
+		}
+		return dashedIP(ip) + "." + addressing.Service.Name + "." + pod.Namespace + ".svc." + addressing.ClusterDomain, nil
+	}
+	return ip, nil
+}
+
+// First is the pod's first address and its name, for commands that send a
+// single request to each pod
+func (addressing *PodAddressing) First(pod *v1.Pod) (ip string, host string, err error) {
+	ips, err := requestIPs(pod, addressing.Family)
+	if err != nil {
+		return "", "", err
+	}
+	host, err = addressing.Host(pod, ips[0])
+	return ips[0], host, err
+}
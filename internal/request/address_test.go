@@ -0,0 +1,67 @@
+package request
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestHost(t *testing.T) {
+	headless := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "web"},
+		Spec:       v1.ServiceSpec{ClusterIP: v1.ClusterIPNone},
+	}
+	clusterIP := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "web"},
+		Spec:       v1.ServiceSpec{ClusterIP: "10.96.0.10"},
+	}
+	deploymentPod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web-7d9f-abcde", Namespace: "shop"}}
+	statefulPod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "web-0", Namespace: "shop"},
+		Spec:       v1.PodSpec{Hostname: "web-0", Subdomain: "web"},
+	}
+
+	tests := []struct {
+		name    string
+		mode    AddressMode
+		service *v1.Service
+		pod     *v1.Pod
+		ip      string
+		want    string
+	}{
+		{"ip", AddressIP, nil, deploymentPod, "10.0.0.1", "10.0.0.1"},
+		{"pod dns", AddressPodDNS, nil, deploymentPod, "10.0.0.1", "10-0-0-1.shop.pod.cluster.local"},
+		{"pod dns ipv6", AddressPodDNS, nil, deploymentPod, "fd00::1", "fd00--1.shop.pod.cluster.local"},
+		{"hostname and subdomain", AddressHostname, nil, statefulPod, "10.0.0.1", "web-0.web.shop.svc.cluster.local"},
+		{"headless without hostname", AddressHostname, headless, deploymentPod, "10.0.0.1", "10-0-0-1.web.shop.svc.cluster.local"},
+	}
+	for _, test := range tests {
+		addressing := &PodAddressing{Mode: test.mode, ClusterDomain: DefaultClusterDomain, Service: test.service}
+		got, err := addressing.Host(test.pod, test.ip)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%s: got %s, want %s", test.name, got, test.want)
+		}
+	}
+
+	failures := []struct {
+		name    string
+		mode    AddressMode
+		service *v1.Service
+		ip      string
+	}{
+		{"hostname without a headless service", AddressHostname, clusterIP, "10.0.0.1"},
+		{"headless without an IP", AddressHostname, headless, ""},
+		{"pod dns without an IP", AddressPodDNS, nil, ""},
+	}
+	for _, test := range failures {
+		addressing := &PodAddressing{Mode: test.mode, ClusterDomain: DefaultClusterDomain, Service: test.service}
+		if host, err := addressing.Host(deploymentPod, test.ip); err == nil {
+			t.Errorf("%s: expected an error, got %s", test.name, host)
+		}
+	}
+}
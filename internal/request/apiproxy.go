@@ -0,0 +1,95 @@
+package request
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/mini-ninja-64/flotilla/internal/kube"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/rest"
+)
+
+// errAPIProxyAuthorization is returned for requests with their own
+// credentials, the API server would take the header as ours (client-go does
+// not add the kube token when one is set) and the pod would never see it
+var errAPIProxyAuthorization = errors.New("the apiproxy transport cannot send an Authorization header, it is used to authenticate with the API server")
+
+// apiProxyTransport sends requests through the API server's pods/proxy
+// subresource, so only HTTPS to the API server is needed
+type apiProxyTransport struct {
+	kubeClient *kube.KubeClient
+	apiClient  *http.Client
+	port       uint16
+}
+
+func newAPIProxyTransport(kubeClient *kube.KubeClient, port uint16, headers map[string]string) (*apiProxyTransport, error) {
+	for headerName := range headers {
+		if http.CanonicalHeaderKey(headerName) == "Authorization" {
+			return nil, errAPIProxyAuthorization
+		}
+	}
+	apiClient, err := rest.HTTPClientFor(kubeClient.Config)
+	if err != nil {
+		return nil, err
+	}
+	return &apiProxyTransport{
+		kubeClient: kubeClient,
+		apiClient:  apiClient,
+		port:       port,
+	}, nil
+}
+
+func (proxy *apiProxyTransport) Name() string {
+	return TransportAPIProxy
+}
+
+func (proxy *apiProxyTransport) Client(pod *v1.Pod) *http.Client {
+	return &http.Client{
+		Transport: &apiProxyRoundTripper{
+			proxy: proxy,
+			pod:   pod,
+		},
+	}
+}
+
+func (proxy *apiProxyTransport) Forget(*v1.Pod) {}
+
+func (proxy *apiProxyTransport) Close() {
+	proxy.apiClient.CloseIdleConnections()
+}
+
+// apiProxyRoundTripper rewrites requests for a pod into requests to its
+// proxy subresource
+type apiProxyRoundTripper struct {
+	proxy *apiProxyTransport
+	pod   *v1.Pod
+}
+
+func (roundTripper *apiProxyRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("Authorization") != "" {
+		return nil, errAPIProxyAuthorization
+	}
+	// The proxy subresource is addressed as [scheme:]name:port, http is the default
+	name := fmt.Sprintf("%s:%d", roundTripper.pod.Name, roundTripper.proxy.port)
+	if req.URL.Scheme == "https" {
+		name = "https:" + name
+	}
+	proxyURL := roundTripper.proxy.kubeClient.Client.
+		CoreV1().
+		RESTClient().
+		Get().
+		Resource("pods").
+		Namespace(roundTripper.pod.Namespace).
+		Name(name).
+		SubResource("proxy").
+		URL()
+	proxyURL.Path = strings.TrimSuffix(proxyURL.Path, "/") + req.URL.EscapedPath()
+	proxyURL.RawQuery = req.URL.RawQuery
+
+	proxied := req.Clone(req.Context())
+	proxied.URL = proxyURL
+	proxied.Host = ""
+	return roundTripper.proxy.apiClient.Transport.RoundTrip(proxied)
+}
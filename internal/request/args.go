@@ -0,0 +1,180 @@
+package request
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mini-ninja-64/flotilla/internal/kube"
+	"github.com/mini-ninja-64/flotilla/internal/util"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// Args are the options shared by every command that sends requests to the
+// pods of a service
+type Args struct {
+	Protocol            string
+	ServiceName         string
+	Port                uint16
+	Path                string
+	Method              string
+	Headers             map[string]string
+	Timeout             time.Duration
+	ConnectTimeout      time.Duration
+	Transport           string
+	PortForwardProtocol kube.PortForwardProtocol
+	DebugImage          string
+	IPFamily            IPFamily
+	AddressMode         AddressMode
+	ClusterDomain       string
+}
+
+const defaultTimeoutFlag = "timeout"
+
+func AddFlags(flags *pflag.FlagSet) {
+	AddFlagsWithTimeout(flags, defaultTimeoutFlag)
+}
+
+// AddFlagsWithTimeout registers the per request timeout under a different
+// name, for commands that use --timeout for something else
+func AddFlagsWithTimeout(flags *pflag.FlagSet, timeoutFlag string) {
+	flags.StringP("method", "m", "GET", "The HTTP Method to use")
+	AddConnectionFlags(flags, timeoutFlag)
+}
+
+// AddConnectionFlags registers the flags that control how pods are reached,
+// for commands that take the method and path from somewhere else
+func AddConnectionFlags(flags *pflag.FlagSet, timeoutFlag string) {
+	// TODO: Should probs support duplicate headers, we currently do not oooops
+	flags.StringToStringP("header", "H", map[string]string{}, "The HTTP header to add in the form name=value")
+	flags.Uint16P("port", "p", 0, "The port to use for the reques (by default this is inferred from protocol)")
+	flags.StringP("protocol", "P", "http", "The protocol to use (http/https)")
+	flags.Duration(timeoutFlag, 0, "The maximum time a single attempt at a pod request may take, including connecting and reading the body (0 for no timeout)")
+	flags.Duration("connect-timeout", 0, "The maximum time to spend establishing a connection to a pod (0 for no timeout)")
+	flags.String("transport", TransportAuto, "How to reach pods: auto (directly in cluster, through a port forward otherwise), direct, portforward, apiproxy (through the API server's pods/proxy subresource), exec (with curl or wget inside the container, reaching ports bound to 127.0.0.1) or ephemeral (like exec, from a debug container added to each pod)")
+	flags.String("portforward-protocol", string(kube.PortForwardAuto), "How port forwards connect to the API server: auto (websocket, falling back to spdy), websocket or spdy")
+	flags.String("ip-family", string(IPFamilyPrimary), "Which pod addresses to request: primary (the pod's first IP), ipv4, ipv6 or both (each pod once per family, only with the direct transport)")
+	flags.String("address-mode", string(AddressIP), "How pods are addressed in URLs and Host headers: ip, pod-dns (the pod's A record, e.g. 10-0-0-1.ns.pod.cluster.local) or hostname (the pod's hostname under its subdomain, e.g. pod-0.svc.ns.svc.cluster.local, or for pods without both the dashed IP under a headless service, e.g. 10-0-0-1.svc.ns.svc.cluster.local)")
+	flags.String("cluster-domain", DefaultClusterDomain, "The cluster's DNS domain, used by --address-mode pod-dns and hostname")
+	flags.String("debug-image", DefaultDebugImage, "The image for the debug container added to pods by --transport ephemeral, it needs curl or wget")
+}
+
+// ParseArgs reads the flags registered by AddFlags, args are expected to be
+// in the form [service] [path]
+func ParseArgs(cmd *cobra.Command, args []string) (*Args, error) {
+	return ParseArgsWithTimeout(cmd, args, defaultTimeoutFlag)
+}
+
+// ParseArgsWithTimeout reads the flags registered by AddFlagsWithTimeout
+func ParseArgsWithTimeout(cmd *cobra.Command, args []string, timeoutFlag string) (*Args, error) {
+	requestArgs, err := ParseConnectionArgs(cmd, args[0], timeoutFlag)
+	if err != nil {
+		return nil, err
+	}
+	requestArgs.Method, err = cmd.Flags().GetString("method")
+	if err != nil {
+		return nil, err
+	}
+	requestArgs.Path = args[1]
+	return requestArgs, nil
+}
+
+// ErrUnlessSingleFamily rejects --ip-family both, for commands that send a
+// single request to each pod
+func (args *Args) ErrUnlessSingleFamily(command string) error {
+	if args.IPFamily == IPFamilyBoth {
+		return fmt.Errorf("%s sends one request per pod, --ip-family both is not supported", command)
+	}
+	return nil
+}
+
+// ParseConnectionArgs reads the flags registered by AddConnectionFlags, the
+// method and path are left empty
+func ParseConnectionArgs(cmd *cobra.Command, serviceName string, timeoutFlag string) (*Args, error) {
+	protocol, err := cmd.Flags().GetString("protocol")
+	if err != nil {
+		return nil, err
+	}
+	var port uint16
+	if !cmd.Flags().Changed("port") {
+		port, err = util.GetDefaultPortForProtocol(&protocol)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		port, err = cmd.Flags().GetUint16("port")
+		if err != nil {
+			return nil, err
+		}
+	}
+	headers, err := cmd.Flags().GetStringToString("header")
+	if err != nil {
+		return nil, err
+	}
+	timeout, err := cmd.Flags().GetDuration(timeoutFlag)
+	if err != nil {
+		return nil, err
+	}
+	connectTimeout, err := cmd.Flags().GetDuration("connect-timeout")
+	if err != nil {
+		return nil, err
+	}
+	transport, err := cmd.Flags().GetString("transport")
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Contains(transports, transport) {
+		return nil, fmt.Errorf("Unknown transport '%s', expected one of %s", transport, strings.Join(transports, ", "))
+	}
+	portForwardProtocolFlag, err := cmd.Flags().GetString("portforward-protocol")
+	if err != nil {
+		return nil, err
+	}
+	portForwardProtocol, err := kube.ParsePortForwardProtocol(portForwardProtocolFlag)
+	if err != nil {
+		return nil, err
+	}
+	debugImage, err := cmd.Flags().GetString("debug-image")
+	if err != nil {
+		return nil, err
+	}
+	ipFamily, err := cmd.Flags().GetString("ip-family")
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Contains(ipFamilies, IPFamily(ipFamily)) {
+		return nil, fmt.Errorf("Unknown IP family '%s', expected primary, ipv4, ipv6 or both", ipFamily)
+	}
+	addressMode, err := cmd.Flags().GetString("address-mode")
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Contains(addressModes, AddressMode(addressMode)) {
+		return nil, fmt.Errorf("Unknown address mode '%s', expected ip, pod-dns or hostname", addressMode)
+	}
+	// A DNS name does not say which family is dialed
+	if AddressMode(addressMode) != AddressIP && IPFamily(ipFamily) != IPFamilyPrimary {
+		return nil, fmt.Errorf("--ip-family %s needs --address-mode ip", ipFamily)
+	}
+	clusterDomain, err := cmd.Flags().GetString("cluster-domain")
+	if err != nil {
+		return nil, err
+	}
+
+	return &Args{
+		Protocol:            protocol,
+		ServiceName:         serviceName,
+		Port:                port,
+		Headers:             headers,
+		Timeout:             timeout,
+		ConnectTimeout:      connectTimeout,
+		Transport:           transport,
+		PortForwardProtocol: portForwardProtocol,
+		DebugImage:          debugImage,
+		IPFamily:            IPFamily(ipFamily),
+		AddressMode:         AddressMode(addressMode),
+		ClusterDomain:       strings.Trim(clusterDomain, "."),
+	}, nil
+}
@@ -0,0 +1,215 @@
+package request
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/mini-ninja-64/flotilla/internal/kube"
+	"github.com/mini-ninja-64/flotilla/internal/util"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// Transport is how requests reach pods, the client for a pod can be used for
+// any number of requests and Close releases anything held open between them
+type Transport interface {
+	// Name is the transport actually in use, auto resolves to another one
+	Name() string
+	Client(pod *v1.Pod) *http.Client
+	// Forget releases anything held for a pod that has gone away
+	Forget(pod *v1.Pod)
+	Close()
+}
+
+const (
+	TransportAuto        = "auto"
+	TransportDirect      = "direct"
+	TransportPortForward = "portforward"
+	TransportAPIProxy    = "apiproxy"
+	TransportExec        = "exec"
+	TransportEphemeral   = "ephemeral"
+)
+
+var transports = []string{TransportAuto, TransportDirect, TransportPortForward, TransportAPIProxy, TransportExec, TransportEphemeral}
+
+// NewTransport picks how pods are reached from the transport in the args,
+// auto dials pods directly when running in cluster and tunnels through a port
+// forward otherwise
+func NewTransport(kubeClient *kube.KubeClient, port uint16, args *Args) (Transport, error) {
+	transport := args.Transport
+	if transport == TransportAuto {
+		transport = TransportPortForward
+		if kubeClient.ClientType == kube.InCluster {
+			transport = TransportDirect
+		}
+	}
+
+	// Every other transport ignores the pod IP, so the family could not be
+	// told apart
+	if args.IPFamily != IPFamilyPrimary && transport != TransportDirect {
+		return nil, fmt.Errorf("--ip-family %s needs the direct transport, not %s", args.IPFamily, transport)
+	}
+
+	switch transport {
+	case TransportDirect:
+		return newDirectTransport(args.ConnectTimeout), nil
+	case TransportPortForward:
+		return newPortForwardTransport(kubeClient, port, args.ConnectTimeout, args.PortForwardProtocol), nil
+	case TransportAPIProxy:
+		return newAPIProxyTransport(kubeClient, port, args.Headers)
+	case TransportExec:
+		return newExecTransport(TransportExec, kubeClient, port, args.ConnectTimeout, portContainer(port)), nil
+	case TransportEphemeral:
+		return newEphemeralTransport(kubeClient, port, args.ConnectTimeout, args.DebugImage), nil
+	}
+	return nil, fmt.Errorf("Unknown transport '%s'", transport)
+}
+
+// directTransport dials pod IPs, which only works from inside the cluster
+type directTransport struct {
+	client *http.Client
+}
+
+func newDirectTransport(connectTimeout time.Duration) *directTransport {
+	dialer := &net.Dialer{Timeout: connectTimeout}
+	return &directTransport{
+		client: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, network string, addr string) (net.Conn, error) {
+					// Pods without an IP yet have no host, which would dial
+					// ourselves
+					if host, _, err := net.SplitHostPort(addr); err == nil && host == "" {
+						return nil, fmt.Errorf("Pod has %w to dial", errNoPodIP)
+					}
+					conn, err := dialer.DialContext(ctx, network, addr)
+					return conn, util.ConnectTimeout(ctx, err)
+				},
+			},
+		},
+	}
+}
+
+func (direct *directTransport) Name() string {
+	return TransportDirect
+}
+
+func (direct *directTransport) Client(*v1.Pod) *http.Client {
+	return direct.client
+}
+
+func (direct *directTransport) Forget(*v1.Pod) {}
+
+func (direct *directTransport) Close() {
+	direct.client.CloseIdleConnections()
+}
+
+// portForwardTransport gives each pod its own client so keep-alive
+// connections are reused, a new connection is a new pair of streams over the
+// pod's pooled tunnel
+type portForwardTransport struct {
+	tunnels        *kube.TunnelPool
+	connectTimeout time.Duration
+	mu             sync.Mutex
+	clients        map[types.UID]*http.Client
+}
+
+func newPortForwardTransport(kubeClient *kube.KubeClient, port uint16, connectTimeout time.Duration, protocol kube.PortForwardProtocol) *portForwardTransport {
+	return &portForwardTransport{
+		tunnels:        kube.NewTunnelPool(kubeClient, port, protocol),
+		connectTimeout: connectTimeout,
+		clients:        map[types.UID]*http.Client{},
+	}
+}
+
+func (portForward *portForwardTransport) Name() string {
+	return TransportPortForward
+}
+
+func (portForward *portForwardTransport) dial(ctx context.Context, pod *v1.Pod) (net.Conn, error) {
+	connectCtx, cancelConnect := util.WithOptionalTimeout(ctx, portForward.connectTimeout)
+	defer cancelConnect()
+	tunnel, err := portForward.tunnels.Get(connectCtx, pod)
+	if err != nil {
+		return nil, util.ConnectTimeout(ctx, err)
+	}
+	conn, err := tunnel.Dial()
+	if err != nil {
+		portForward.tunnels.Evict(pod, tunnel, err)
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (portForward *portForwardTransport) Client(pod *v1.Pod) *http.Client {
+	portForward.mu.Lock()
+	defer portForward.mu.Unlock()
+	if client, ok := portForward.clients[pod.UID]; ok {
+		return client
+	}
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network string, addr string) (net.Conn, error) {
+				return portForward.dial(ctx, pod)
+			},
+		},
+	}
+	portForward.clients[pod.UID] = client
+	return client
+}
+
+// Forget closes the pod's tunnel, which would otherwise keep trying to
+// reconnect to a pod that no longer exists
+func (portForward *portForwardTransport) Forget(pod *v1.Pod) {
+	portForward.mu.Lock()
+	client, ok := portForward.clients[pod.UID]
+	delete(portForward.clients, pod.UID)
+	portForward.mu.Unlock()
+	if ok {
+		client.CloseIdleConnections()
+	}
+	portForward.tunnels.Remove(pod)
+}
+
+func (portForward *portForwardTransport) Close() {
+	portForward.mu.Lock()
+	defer portForward.mu.Unlock()
+	for _, client := range portForward.clients {
+		client.CloseIdleConnections()
+	}
+	portForward.tunnels.Close()
+}
+
+// OnTunnelEvent reports port forward tunnels dropping and reconnecting, it
+// does nothing for transports without tunnels
+func OnTunnelEvent(transport Transport, onEvent func(kube.TunnelEvent)) {
+	if portForward, ok := transport.(*portForwardTransport); ok {
+		portForward.tunnels.OnEvent(onEvent)
+	}
+}
+
+// Do sends a pod request through the transport and reads the whole response
+// body
+func Do(ctx context.Context, transport Transport, podRequest *PodRequest) *PodHttpResponse {
+	result := &PodHttpResponse{
+		Pod:       podRequest.Pod,
+		Transport: transport.Name(),
+	}
+	if podRequest.Err != nil {
+		result.Error = podRequest.Err
+		return result
+	}
+	response, err := transport.Client(podRequest.Pod).Do(podRequest.Request.Clone(ctx))
+	if err != nil {
+		result.Error = err
+		return result
+	}
+	defer response.Body.Close()
+	result.Response = response
+	result.Body, result.Error = io.ReadAll(response.Body)
+	return result
+}
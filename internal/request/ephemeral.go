@@ -0,0 +1,62 @@
+package request
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/mini-ninja-64/flotilla/internal/kube"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const DefaultDebugImage = "curlimages/curl:8.11.1"
+
+type debugContainer struct {
+	mu   sync.Mutex
+	name string
+}
+
+// debugContainers injects at most one debug container per pod, concurrent
+// requests to a new pod wait for the same container to start. The returned
+// func forgets a pod that has gone away
+func debugContainers(kubeClient *kube.KubeClient, image string) (containerPicker, func(pod *v1.Pod)) {
+	var mu sync.Mutex
+	containers := map[types.UID]*debugContainer{}
+	forget := func(pod *v1.Pod) {
+		mu.Lock()
+		defer mu.Unlock()
+		delete(containers, pod.UID)
+	}
+	pick := func(ctx context.Context, pod *v1.Pod) (string, error) {
+		mu.Lock()
+		container, ok := containers[pod.UID]
+		if !ok {
+			container = &debugContainer{}
+			containers[pod.UID] = container
+		}
+		mu.Unlock()
+
+		container.mu.Lock()
+		defer container.mu.Unlock()
+		if container.name != "" {
+			return container.name, nil
+		}
+		name, err := kube.EnsureDebugContainer(ctx, kubeClient, pod, image)
+		if err != nil {
+			return "", err
+		}
+		container.name = name
+		return name, nil
+	}
+	return pick, forget
+}
+
+// newEphemeralTransport is the exec transport run from a debug container in
+// the pod's network namespace, for containers with no HTTP client
+func newEphemeralTransport(kubeClient *kube.KubeClient, port uint16, connectTimeout time.Duration, image string) *execTransport {
+	pick, forget := debugContainers(kubeClient, image)
+	exec := newExecTransport(TransportEphemeral, kubeClient, port, connectTimeout, pick)
+	exec.forgetContainer = forget
+	return exec
+}
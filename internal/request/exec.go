@@ -0,0 +1,297 @@
+package request
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mini-ninja-64/flotilla/internal/kube"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	curlClient = "curl"
+	wgetClient = "wget"
+)
+
+// execTransport sends requests from inside a container of the pod with curl
+// (or wget when there is no curl), so ports bound to 127.0.0.1 can be reached.
+// It remembers which HTTP client worked in each pod, so later requests do not
+// have to probe for it again
+type execTransport struct {
+	name           string
+	kubeClient     *kube.KubeClient
+	port           uint16
+	connectTimeout time.Duration
+	container      containerPicker
+	mu             sync.Mutex
+	found          map[types.UID]string
+	// keepURL sends requests to their URL as given rather than to the port
+	// on the pod's loopback, for pods used as a helper to reach other hosts
+	keepURL bool
+	// forgetContainer drops what the container picker holds for a pod
+	forgetContainer func(pod *v1.Pod)
+}
+
+// containerPicker decides which container of a pod requests are run from
+type containerPicker = func(ctx context.Context, pod *v1.Pod) (string, error)
+
+type execRoundTripper struct {
+	exec *execTransport
+	pod  *v1.Pod
+}
+
+// portContainer runs requests from the container that serves the port
+func portContainer(port uint16) containerPicker {
+	return func(ctx context.Context, pod *v1.Pod) (string, error) {
+		return kube.ContainerForPort(pod, port), nil
+	}
+}
+
+func newExecTransport(name string, kubeClient *kube.KubeClient, port uint16, connectTimeout time.Duration, container containerPicker) *execTransport {
+	return &execTransport{
+		name:           name,
+		kubeClient:     kubeClient,
+		port:           port,
+		connectTimeout: connectTimeout,
+		container:      container,
+		found:          map[types.UID]string{},
+	}
+}
+
+func (exec *execTransport) Name() string {
+	return exec.name
+}
+
+func (exec *execTransport) Client(pod *v1.Pod) *http.Client {
+	return &http.Client{
+		Transport: &execRoundTripper{
+			exec: exec,
+			pod:  pod,
+		},
+		// Redirects would be followed from outside the pod
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+func (exec *execTransport) Forget(pod *v1.Pod) {
+	exec.mu.Lock()
+	delete(exec.found, pod.UID)
+	exec.mu.Unlock()
+	if exec.forgetContainer != nil {
+		exec.forgetContainer(pod)
+	}
+}
+
+// Close leaves debug containers in place, they cannot be removed and are
+// reused by later runs
+func (exec *execTransport) Close() {}
+
+func (exec *execTransport) candidates(pod *v1.Pod) []string {
+	exec.mu.Lock()
+	defer exec.mu.Unlock()
+	if found, ok := exec.found[pod.UID]; ok {
+		return []string{found}
+	}
+	return []string{curlClient, wgetClient}
+}
+
+func (exec *execTransport) remember(pod *v1.Pod, client string) {
+	exec.mu.Lock()
+	defer exec.mu.Unlock()
+	exec.found[pod.UID] = client
+}
+
+func (roundTripper *execRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	container, err := roundTripper.exec.container(req.Context(), roundTripper.pod)
+	if err != nil {
+		return nil, err
+	}
+	for _, client := range roundTripper.exec.candidates(roundTripper.pod) {
+		var response *http.Response
+		var err error
+		var stderr string
+		if client == curlClient {
+			response, stderr, err = roundTripper.curl(req, container, body)
+		} else {
+			response, stderr, err = roundTripper.wget(req, container, body)
+		}
+		if err != nil && kube.IsExecutableNotFound(err, stderr) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		roundTripper.exec.remember(roundTripper.pod, client)
+		return response, nil
+	}
+	return nil, fmt.Errorf("Neither curl nor wget is available in container '%s'", container)
+}
+
+// namedHost is the DNS name the request is addressed to, when it is sent to
+// the pod's loopback and was not addressed by IP
+func (roundTripper *execRoundTripper) namedHost(req *http.Request) (string, bool) {
+	host := req.URL.Hostname()
+	if roundTripper.exec.keepURL || host == "" || net.ParseIP(host) != nil {
+		return "", false
+	}
+	return host, true
+}
+
+func (roundTripper *execRoundTripper) localURL(req *http.Request) string {
+	if roundTripper.exec.keepURL {
+		return req.URL.String()
+	}
+	return fmt.Sprintf("%s://127.0.0.1:%d%s", req.URL.Scheme, roundTripper.exec.port, req.URL.RequestURI())
+}
+
+func (roundTripper *execRoundTripper) run(req *http.Request, container string, command []string, stdin []byte) ([]byte, string, error) {
+	var stdinReader io.Reader
+	if stdin != nil {
+		stdinReader = bytes.NewReader(stdin)
+	}
+	var stdout, stderr bytes.Buffer
+	err := kube.Exec(req.Context(), roundTripper.exec.kubeClient, roundTripper.pod, container, command, stdinReader, &stdout, &stderr)
+	return stdout.Bytes(), strings.TrimSpace(stderr.String()), err
+}
+
+// curl writes the raw response (--raw keeps the transfer encoding intact) so
+// it can be parsed exactly as it came off the wire
+func (roundTripper *execRoundTripper) curl(req *http.Request, container string, body []byte) (*http.Response, string, error) {
+	command := []string{curlClient, "--silent", "--show-error", "--include", "--raw", "--http1.1", "--request", req.Method}
+	if roundTripper.exec.connectTimeout > 0 {
+		command = append(command, "--connect-timeout", strconv.FormatFloat(roundTripper.exec.connectTimeout.Seconds(), 'f', -1, 64))
+	}
+	for headerName, headerValues := range req.Header {
+		for _, headerValue := range headerValues {
+			command = append(command, "--header", headerName+": "+headerValue)
+		}
+	}
+	if body != nil {
+		command = append(command, "--data-binary", "@-")
+	}
+	url := roundTripper.localURL(req)
+	if host, ok := roundTripper.namedHost(req); ok {
+		// Connect to the loopback while keeping the name for SNI and the Host header
+		port := strconv.Itoa(int(roundTripper.exec.port))
+		command = append(command, "--resolve", host+":"+port+":127.0.0.1")
+		url = req.URL.Scheme + "://" + net.JoinHostPort(host, port) + req.URL.RequestURI()
+	}
+	command = append(command, url)
+
+	stdout, stderr, err := roundTripper.run(req, container, command, body)
+	if err != nil {
+		if stderr != "" {
+			return nil, stderr, fmt.Errorf("%s: %w", stderr, err)
+		}
+		return nil, stderr, err
+	}
+
+	reader := bufio.NewReader(bytes.NewReader(stdout))
+	for {
+		response, err := http.ReadResponse(reader, req)
+		if err != nil {
+			return nil, stderr, fmt.Errorf("Unable to parse curl output: %w", err)
+		}
+		// Interim responses (e.g. 100 Continue) are printed before the real one
+		if response.StatusCode >= 200 || response.StatusCode < 100 {
+			return response, stderr, nil
+		}
+	}
+}
+
+// wget only reports headers on stderr (with -S) and only supports GET and
+// POST, it is a fallback for containers without curl
+func (roundTripper *execRoundTripper) wget(req *http.Request, container string, body []byte) (*http.Response, string, error) {
+	command := []string{wgetClient, "-q", "-S", "-O", "-"}
+	if roundTripper.exec.connectTimeout > 0 {
+		command = append(command, "-T", strconv.Itoa(int(roundTripper.exec.connectTimeout.Round(time.Second).Seconds())))
+	}
+	for headerName, headerValues := range req.Header {
+		for _, headerValue := range headerValues {
+			command = append(command, "--header", headerName+": "+headerValue)
+		}
+	}
+	if host, ok := roundTripper.namedHost(req); ok {
+		command = append(command, "--header", "Host: "+net.JoinHostPort(host, strconv.Itoa(int(roundTripper.exec.port))))
+	}
+	switch req.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		command = append(command, "--post-data", string(body))
+	default:
+		return nil, "", fmt.Errorf("wget can only send GET and POST requests, '%s' needs curl in the container", req.Method)
+	}
+	command = append(command, roundTripper.localURL(req))
+
+	stdout, stderr, err := roundTripper.run(req, container, command, nil)
+	response := parseWgetHeaders(stderr)
+	if response == nil {
+		if err == nil {
+			err = fmt.Errorf("wget did not report a response")
+		}
+		if stderr != "" {
+			return nil, stderr, fmt.Errorf("%s: %w", stderr, err)
+		}
+		return nil, stderr, err
+	}
+	// wget exits with an error for 4xx and 5xx responses, which are still
+	// responses as far as we are concerned
+	response.Body = io.NopCloser(bytes.NewReader(stdout))
+	response.ContentLength = int64(len(stdout))
+	response.Request = req
+	return response, stderr, nil
+}
+
+// parseWgetHeaders reads the last response reported by wget -S, the status
+// and header lines are indented and earlier responses belong to redirects
+func parseWgetHeaders(output string) *http.Response {
+	var response *http.Response
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "HTTP/") {
+			proto, status, _ := strings.Cut(line, " ")
+			code, _, _ := strings.Cut(status, " ")
+			statusCode, err := strconv.Atoi(code)
+			if err != nil {
+				continue
+			}
+			response = &http.Response{
+				Status:     status,
+				StatusCode: statusCode,
+				Proto:      proto,
+				Header:     http.Header{},
+			}
+			response.ProtoMajor, response.ProtoMinor, _ = http.ParseHTTPVersion(proto)
+			continue
+		}
+		if response == nil {
+			continue
+		}
+		if name, value, ok := strings.Cut(line, ":"); ok && !strings.Contains(name, " ") {
+			response.Header.Add(name, strings.TrimSpace(value))
+		}
+	}
+	return response
+}
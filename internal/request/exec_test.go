@@ -0,0 +1,46 @@
+package request
+
+import "testing"
+
+func TestParseWgetHeaders(t *testing.T) {
+	// wget -S prints every response it follows, the last one is the answer
+	output := `Connecting to 10.0.0.1:8080 (10.0.0.1:8080)
+  HTTP/1.1 302 Found
+  Location: /health
+  Connection: close
+  HTTP/1.1 200 OK
+  Content-Type: application/json
+  Set-Cookie: a=1
+  Set-Cookie: b=2
+  Connection: close
+saving to 'STDOUT'
+`
+	response := parseWgetHeaders(output)
+	if response == nil {
+		t.Fatal("expected a response")
+	}
+	if response.StatusCode != 200 || response.Status != "200 OK" {
+		t.Errorf("expected 200 OK, got %d %q", response.StatusCode, response.Status)
+	}
+	if response.ProtoMajor != 1 || response.ProtoMinor != 1 {
+		t.Errorf("expected HTTP/1.1, got %s", response.Proto)
+	}
+	if response.Header.Get("Location") != "" {
+		t.Error("expected headers from the redirect to be dropped")
+	}
+	if response.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("expected the content type, got %q", response.Header.Get("Content-Type"))
+	}
+	if cookies := response.Header.Values("Set-Cookie"); len(cookies) != 2 {
+		t.Errorf("expected both cookies, got %v", cookies)
+	}
+	if _, ok := response.Header["Saving to 'STDOUT'"]; ok || len(response.Header) != 3 {
+		t.Errorf("expected only response headers, got %v", response.Header)
+	}
+}
+
+func TestParseWgetHeadersWithoutResponse(t *testing.T) {
+	if response := parseWgetHeaders("wget: can't connect to remote host: Connection refused\n"); response != nil {
+		t.Errorf("expected no response, got %v", response)
+	}
+}
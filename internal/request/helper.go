@@ -0,0 +1,35 @@
+package request
+
+import (
+	"fmt"
+
+	"github.com/mini-ninja-64/flotilla/internal/kube"
+)
+
+// NewHelperTransport is for requests to addresses outside of any pod (e.g.
+// node ports), they are sent to their URL as given. In cluster they are dialed
+// directly, outside the cluster they are run from inside the helper pod passed
+// to Client with exec (or from a debug container added to it with ephemeral)
+func NewHelperTransport(kubeClient *kube.KubeClient, args *Args) (Transport, error) {
+	transport := args.Transport
+	if transport == TransportAuto {
+		transport = TransportExec
+		if kubeClient.ClientType == kube.InCluster {
+			transport = TransportDirect
+		}
+	}
+
+	switch transport {
+	case TransportDirect:
+		return newDirectTransport(args.ConnectTimeout), nil
+	case TransportExec:
+		exec := newExecTransport(TransportExec, kubeClient, 0, args.ConnectTimeout, portContainer(0))
+		exec.keepURL = true
+		return exec, nil
+	case TransportEphemeral:
+		exec := newEphemeralTransport(kubeClient, 0, args.ConnectTimeout, args.DebugImage)
+		exec.keepURL = true
+		return exec, nil
+	}
+	return nil, fmt.Errorf("--transport %s cannot reach addresses outside of pods, use direct, exec or ephemeral", transport)
+}
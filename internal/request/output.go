@@ -0,0 +1,204 @@
+package request
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mini-ninja-64/flotilla/internal/kube"
+	"sigs.k8s.io/yaml"
+)
+
+// OutputFormat is how per-pod results are written to stdout, json and yaml
+// write every record sorted by pod once the run is over while ndjson writes
+// each record as soon as its response arrives
+type OutputFormat string
+
+const (
+	OutputNone   OutputFormat = ""
+	OutputJSON   OutputFormat = "json"
+	OutputYAML   OutputFormat = "yaml"
+	OutputNDJSON OutputFormat = "ndjson"
+)
+
+var outputFormats = []OutputFormat{OutputNone, OutputJSON, OutputYAML, OutputNDJSON}
+
+func ParseOutputFormat(format string) (OutputFormat, error) {
+	if !slices.Contains(outputFormats, OutputFormat(format)) {
+		return OutputNone, fmt.Errorf("Unknown output format '%s', expected json, yaml or ndjson", format)
+	}
+	return OutputFormat(format), nil
+}
+
+// ResponseRecord is the structured result of one pod request, bodies are
+// written as text so binary responses are not preserved exactly
+type ResponseRecord struct {
+	Namespace  string          `json:"namespace"`
+	Pod        string          `json:"pod"`
+	Node       string          `json:"node,omitempty"`
+	IP         string          `json:"ip"`
+	Container  string          `json:"container,omitempty"`
+	URL        string          `json:"url"`
+	Method     string          `json:"method"`
+	Transport  string          `json:"transport,omitempty"`
+	StatusCode int             `json:"statusCode,omitempty"`
+	Headers    http.Header     `json:"headers,omitempty"`
+	Body       string          `json:"body,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	StartedAt  *time.Time      `json:"startedAt,omitempty"`
+	DurationMs float64         `json:"durationMs"`
+	Attempts   []AttemptRecord `json:"attempts,omitempty"`
+}
+
+type AttemptRecord struct {
+	Number     uint      `json:"number"`
+	StatusCode int       `json:"statusCode,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	StartedAt  time.Time `json:"startedAt"`
+	DurationMs float64   `json:"durationMs"`
+}
+
+func milliseconds(duration time.Duration) float64 {
+	return float64(duration.Microseconds()) / 1000
+}
+
+// NewResponseRecord records a request and its response, a nil response means
+// the request was never sent. The duration covers every attempt and the
+// delays between them
+func NewResponseRecord(podRequest *PodRequest, response *PodHttpResponse) ResponseRecord {
+	pod := podRequest.Pod
+	record := ResponseRecord{
+		Namespace: pod.Namespace,
+		Pod:       pod.Name,
+		Node:      pod.Spec.NodeName,
+		IP:        podRequest.IP,
+		URL:       podRequest.Request.URL.String(),
+		Method:    podRequest.Request.Method,
+	}
+	if port, err := strconv.ParseUint(podRequest.Request.URL.Port(), 10, 16); err == nil {
+		record.Container = kube.ContainerForPort(pod, uint16(port))
+	}
+	if response == nil {
+		record.Error = "not sent"
+		return record
+	}
+
+	record.Transport = response.Transport
+	if response.Response != nil {
+		record.StatusCode = response.Response.StatusCode
+		record.Headers = response.Response.Header
+	}
+	record.Body = string(response.Body)
+	if response.Error != nil {
+		record.Error = response.Error.Error()
+	}
+	for _, attempt := range response.Attempts {
+		attemptRecord := AttemptRecord{
+			Number:     attempt.Number,
+			StatusCode: attempt.StatusCode,
+			StartedAt:  attempt.StartedAt,
+			DurationMs: milliseconds(attempt.Duration),
+		}
+		if attempt.Error != nil {
+			attemptRecord.Error = attempt.Error.Error()
+		}
+		record.Attempts = append(record.Attempts, attemptRecord)
+	}
+	if len(response.Attempts) > 0 {
+		first := response.Attempts[0]
+		last := response.Attempts[len(response.Attempts)-1]
+		record.StartedAt = &first.StartedAt
+		record.DurationMs = milliseconds(last.StartedAt.Add(last.Duration).Sub(first.StartedAt))
+	}
+	return record
+}
+
+func compareRecords(a ResponseRecord, b ResponseRecord) int {
+	return cmp.Or(
+		cmp.Compare(a.Namespace, b.Namespace),
+		cmp.Compare(a.Pod, b.Pod),
+		cmp.Compare(a.IP, b.IP),
+		cmp.Compare(a.URL, b.URL),
+	)
+}
+
+// OutputWriter collects response records, it is safe to use from many
+// goroutines and a nil OutputWriter discards everything
+type OutputWriter struct {
+	mu      sync.Mutex
+	format  OutputFormat
+	writer  io.Writer
+	records []ResponseRecord
+	err     error
+}
+
+// NewOutputWriter returns nil when no output format was asked for
+func NewOutputWriter(format OutputFormat, writer io.Writer) *OutputWriter {
+	if format == OutputNone {
+		return nil
+	}
+	return &OutputWriter{
+		format:  format,
+		writer:  writer,
+		records: []ResponseRecord{},
+	}
+}
+
+func (output *OutputWriter) Write(podRequest *PodRequest, response *PodHttpResponse) {
+	if output == nil {
+		return
+	}
+	record := NewResponseRecord(podRequest, response)
+	output.mu.Lock()
+	defer output.mu.Unlock()
+	if output.format != OutputNDJSON {
+		output.records = append(output.records, record)
+		return
+	}
+	if output.err != nil {
+		return
+	}
+	line, err := json.Marshal(record)
+	if err == nil {
+		_, err = output.writer.Write(append(line, '\n'))
+	}
+	output.err = err
+}
+
+// Flush writes the collected records ordered by namespace and pod, so the
+// output is the same whichever order the responses arrived in. It returns the
+// first error hit while writing any of them
+func (output *OutputWriter) Flush() error {
+	if output == nil {
+		return nil
+	}
+	output.mu.Lock()
+	defer output.mu.Unlock()
+	if output.err != nil {
+		return output.err
+	}
+
+	slices.SortStableFunc(output.records, compareRecords)
+	var data []byte
+	var err error
+	switch output.format {
+	case OutputJSON:
+		data, err = json.MarshalIndent(output.records, "", "  ")
+		data = append(data, '\n')
+	case OutputYAML:
+		data, err = yaml.Marshal(output.records)
+	default:
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	_, err = output.writer.Write(data)
+	return err
+}
@@ -0,0 +1,63 @@
+package request
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"slices"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func outputRequest(pod string) *PodRequest {
+	return &PodRequest{
+		Pod:     &v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: pod}},
+		IP:      "10.0.0.1",
+		Request: &http.Request{Method: http.MethodGet, URL: &url.URL{Scheme: "http", Host: "10.0.0.1:8080", Path: "/"}},
+	}
+}
+
+func TestOutputWriterSortsRecords(t *testing.T) {
+	for _, format := range []OutputFormat{OutputJSON, OutputNDJSON} {
+		var buffer bytes.Buffer
+		output := NewOutputWriter(format, &buffer)
+		// Responses arrive in completion order, unsent pods are written last
+		output.Write(outputRequest("web-2"), &PodHttpResponse{})
+		output.Write(outputRequest("web-0"), &PodHttpResponse{})
+		output.Write(outputRequest("web-1"), nil)
+		if err := output.Flush(); err != nil {
+			t.Fatal(err)
+		}
+
+		pods := []string{}
+		decoder := json.NewDecoder(&buffer)
+		if format == OutputJSON {
+			records := []ResponseRecord{}
+			if err := decoder.Decode(&records); err != nil {
+				t.Fatal(err)
+			}
+			for _, record := range records {
+				pods = append(pods, record.Pod)
+			}
+		} else {
+			for decoder.More() {
+				record := ResponseRecord{}
+				if err := decoder.Decode(&record); err != nil {
+					t.Fatal(err)
+				}
+				pods = append(pods, record.Pod)
+			}
+		}
+
+		want := []string{"web-0", "web-1", "web-2"}
+		if format == OutputNDJSON {
+			want = []string{"web-2", "web-0", "web-1"}
+		}
+		if !slices.Equal(pods, want) {
+			t.Errorf("%s: expected records for %v, got %v", format, want, pods)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package request
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// RunRecord is the persisted result of a fan-out, it holds enough to find the
+// same pods again and repeat the request against the ones that failed
+type RunRecord struct {
+	ID        string            `json:"id"`
+	StartedAt time.Time         `json:"startedAt"`
+	Namespace string            `json:"namespace"`
+	Service   string            `json:"service"`
+	Method    string            `json:"method"`
+	Path      string            `json:"path"`
+	Protocol  string            `json:"protocol"`
+	Port      uint16            `json:"port"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	Pods      []PodRecord       `json:"pods"`
+}
+
+type PodRecord struct {
+	Name       string    `json:"name"`
+	UID        types.UID `json:"uid"`
+	IP         string    `json:"ip"`
+	URL        string    `json:"url,omitempty"`
+	Transport  string    `json:"transport,omitempty"`
+	Succeeded  bool      `json:"succeeded"`
+	StatusCode int       `json:"statusCode,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	Attempts   int       `json:"attempts"`
+}
+
+// NewRunRecord records the outcome of every request, a nil response means
+// the request was never sent
+func NewRunRecord(args *Args, namespace string, startedAt time.Time, requests []PodRequest, responses []*PodHttpResponse) *RunRecord {
+	record := &RunRecord{
+		ID:        uuid.New().String(),
+		StartedAt: startedAt,
+		Namespace: namespace,
+		Service:   args.ServiceName,
+		Method:    args.Method,
+		Path:      args.Path,
+		Protocol:  args.Protocol,
+		Port:      args.Port,
+		Headers:   args.Headers,
+		Pods:      make([]PodRecord, len(requests)),
+	}
+	for idx, podRequest := range requests {
+		podRecord := PodRecord{
+			Name: podRequest.Pod.Name,
+			UID:  podRequest.Pod.UID,
+			IP:   podRequest.IP,
+			URL:  podRequest.Request.URL.String(),
+		}
+		response := responses[idx]
+		switch {
+		case response == nil:
+			podRecord.Error = "not sent"
+		case response.Error != nil:
+			podRecord.Error = response.Error.Error()
+		default:
+			podRecord.StatusCode = response.Response.StatusCode
+			podRecord.Succeeded = response.Response.StatusCode < 400
+		}
+		if response != nil {
+			podRecord.Attempts = len(response.Attempts)
+			podRecord.Transport = response.Transport
+		}
+		record.Pods[idx] = podRecord
+	}
+	return record
+}
+
+func (record *RunRecord) Save(path string) error {
+	contents, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(contents, '\n'), 0o644)
+}
+
+func LoadRunRecord(path string) (*RunRecord, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	record := &RunRecord{}
+	if err := json.Unmarshal(contents, record); err != nil {
+		return nil, fmt.Errorf("Unable to parse run record '%s': %w", path, err)
+	}
+	return record, nil
+}
+
+// CheckMatches makes sure a run is being repeated with the same request it
+// was recorded with
+func (record *RunRecord) CheckMatches(args *Args, namespace string) error {
+	if record.Namespace != namespace || record.Service != args.ServiceName {
+		return fmt.Errorf("Run %s was against service '%s/%s', not '%s/%s'", record.ID, record.Namespace, record.Service, namespace, args.ServiceName)
+	}
+	if record.Method != args.Method || record.Path != args.Path {
+		return fmt.Errorf("Run %s sent '%s %s', not '%s %s'", record.ID, record.Method, record.Path, args.Method, args.Path)
+	}
+	if record.Protocol != args.Protocol || record.Port != args.Port {
+		return fmt.Errorf("Run %s was sent with %s to port %d, not %s to port %d", record.ID, record.Protocol, record.Port, args.Protocol, args.Port)
+	}
+	if !maps.Equal(record.Headers, args.Headers) {
+		return fmt.Errorf("Run %s was sent with different headers", record.ID)
+	}
+	return nil
+}
+
+// SelectFailed narrows pods down to those that failed in the recorded run,
+// failed pods that no longer exist are described in the returned list
+func (record *RunRecord) SelectFailed(pods *v1.PodList) (*v1.PodList, []string) {
+	currentByUID := map[types.UID]v1.Pod{}
+	currentByName := map[string]v1.Pod{}
+	for _, pod := range pods.Items {
+		currentByUID[pod.UID] = pod
+		currentByName[pod.Name] = pod
+	}
+
+	selected := &v1.PodList{}
+	missing := []string{}
+	// A pod requested once per address family is recorded more than once
+	seen := map[types.UID]bool{}
+	for _, podRecord := range record.Pods {
+		if podRecord.Succeeded || seen[podRecord.UID] {
+			continue
+		}
+		seen[podRecord.UID] = true
+		if pod, ok := currentByUID[podRecord.UID]; ok {
+			selected.Items = append(selected.Items, pod)
+		} else if _, ok := currentByName[podRecord.Name]; ok {
+			missing = append(missing, fmt.Sprintf("%s has been replaced by a new pod with the same name", podRecord.Name))
+		} else {
+			missing = append(missing, fmt.Sprintf("%s no longer exists", podRecord.Name))
+		}
+	}
+	return selected, missing
+}
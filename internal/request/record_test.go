@@ -0,0 +1,79 @@
+package request
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestCheckMatches(t *testing.T) {
+	record := &RunRecord{
+		ID:        "run",
+		Namespace: "shop",
+		Service:   "web",
+		Method:    "GET",
+		Path:      "/health",
+		Protocol:  "http",
+		Port:      8080,
+		Headers:   map[string]string{"X-Test": "1"},
+	}
+	matching := func() *Args {
+		return &Args{
+			ServiceName: "web",
+			Method:      "GET",
+			Path:        "/health",
+			Protocol:    "http",
+			Port:        8080,
+			Headers:     map[string]string{"X-Test": "1"},
+		}
+	}
+	if err := record.CheckMatches(matching(), "shop"); err != nil {
+		t.Fatalf("expected the same request to match: %v", err)
+	}
+	if err := record.CheckMatches(matching(), "other"); err == nil {
+		t.Error("expected a different namespace to be rejected")
+	}
+
+	changes := map[string]func(args *Args){
+		"service":  func(args *Args) { args.ServiceName = "api" },
+		"method":   func(args *Args) { args.Method = "POST" },
+		"path":     func(args *Args) { args.Path = "/" },
+		"protocol": func(args *Args) { args.Protocol = "https" },
+		"port":     func(args *Args) { args.Port = 9090 },
+		"headers":  func(args *Args) { args.Headers = map[string]string{} },
+	}
+	for name, change := range changes {
+		args := matching()
+		change(args)
+		if err := record.CheckMatches(args, "shop"); err == nil {
+			t.Errorf("expected a different %s to be rejected", name)
+		}
+	}
+}
+
+func TestSelectFailed(t *testing.T) {
+	pod := func(name string, uid string) v1.Pod {
+		return v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, UID: types.UID("uid-" + uid)}}
+	}
+	kept := pod("web-0", "0")
+	replaced := pod("web-1", "1")
+	passed := pod("web-2", "2")
+	record := &RunRecord{Pods: []PodRecord{
+		{Name: kept.Name, UID: kept.UID},
+		{Name: kept.Name, UID: kept.UID},
+		{Name: replaced.Name, UID: replaced.UID},
+		{Name: passed.Name, UID: passed.UID, Succeeded: true},
+		{Name: "web-3", UID: "gone"},
+	}}
+	current := &v1.PodList{Items: []v1.Pod{kept, pod("web-1", "new"), passed}}
+
+	selected, missing := record.SelectFailed(current)
+	if len(selected.Items) != 1 || selected.Items[0].UID != kept.UID {
+		t.Errorf("expected only %s to be selected once, got %v", kept.Name, selected.Items)
+	}
+	if len(missing) != 2 {
+		t.Errorf("expected the replaced and deleted pods to be reported, got %v", missing)
+	}
+}
@@ -0,0 +1,198 @@
+package request
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+type PodRequest struct {
+	Pod     *v1.Pod
+	Request *http.Request
+	IP      string
+	// Family is only set when a pod is requested once per address family
+	Family IPFamily
+	// Err is set when the pod cannot be addressed, the request is reported as
+	// failed with it rather than being sent
+	Err error
+}
+
+// Name identifies the request in output, the address family is included when
+// a pod is requested once per family
+func (podRequest *PodRequest) Name() string {
+	if podRequest.Family == "" {
+		return podRequest.Pod.Name
+	}
+	return podRequest.Pod.Name + " (" + string(podRequest.Family) + ")"
+}
+
+type PodHttpResponse struct {
+	Response  *http.Response
+	Error     error
+	Pod       *v1.Pod
+	Body      []byte
+	Attempts  []Attempt
+	Transport string
+}
+
+// Attempt records the outcome of a single try at a pod request, a StatusCode
+// of 0 means no response was received
+type Attempt struct {
+	Number     uint
+	StatusCode int
+	Error      error
+	StartedAt  time.Time
+	Duration   time.Duration
+}
+
+// IPFamily picks which of a pod's addresses are requested, primary is the
+// address in Status.PodIP and both requests the pod once per family
+type IPFamily string
+
+const (
+	IPFamilyPrimary IPFamily = "primary"
+	IPFamilyIPv4    IPFamily = "ipv4"
+	IPFamilyIPv6    IPFamily = "ipv6"
+	IPFamilyBoth    IPFamily = "both"
+)
+
+var ipFamilies = []IPFamily{IPFamilyPrimary, IPFamilyIPv4, IPFamilyIPv6, IPFamilyBoth}
+
+func familyOf(ip string) IPFamily {
+	if parsed := net.ParseIP(ip); parsed != nil && parsed.To4() == nil {
+		return IPFamilyIPv6
+	}
+	return IPFamilyIPv4
+}
+
+var errNoPodIP = errors.New("no IP address")
+
+// PodIPs lists the pod's addresses in the family, a pod missing an address
+// that was asked for is an error so dual-stack gaps are not skipped silently
+func PodIPs(pod *v1.Pod, family IPFamily) ([]string, error) {
+	ips := []string{}
+	for _, podIP := range pod.Status.PodIPs {
+		ips = append(ips, podIP.IP)
+	}
+	if len(ips) == 0 && pod.Status.PodIP != "" {
+		ips = append(ips, pod.Status.PodIP)
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("Pod '%s' has %w", pod.Name, errNoPodIP)
+	}
+
+	wanted := []IPFamily{family}
+	switch family {
+	case IPFamilyPrimary:
+		return ips[:1], nil
+	case IPFamilyBoth:
+		wanted = []IPFamily{IPFamilyIPv4, IPFamilyIPv6}
+	}
+	selected := []string{}
+	for _, wantedFamily := range wanted {
+		idx := slices.IndexFunc(ips, func(ip string) bool { return familyOf(ip) == wantedFamily })
+		if idx < 0 {
+			return nil, fmt.Errorf("Pod '%s' has no %s address", pod.Name, wantedFamily)
+		}
+		selected = append(selected, ips[idx])
+	}
+	return selected, nil
+}
+
+// requestIPs is PodIPs for building requests, a pod that has no IP yet (e.g.
+// it is still Pending) gets an empty address since only dialing the pod
+// directly needs one, tunnelling transports reach the pod by name
+func requestIPs(pod *v1.Pod, family IPFamily) ([]string, error) {
+	ips, err := PodIPs(pod, family)
+	if errors.Is(err, errNoPodIP) && family == IPFamilyPrimary {
+		return []string{""}, nil
+	}
+	return ips, err
+}
+
+// HostURL is the URL used to reach a path on a host, IPv6 addresses are
+// bracketed
+func HostURL(host string, protocol string, port uint16, path string) string {
+	return protocol + "://" + net.JoinHostPort(host, strconv.Itoa(int(port))) + path
+}
+
+// PodURL is the URL used to reach a path on a pod's primary address
+func PodURL(pod *v1.Pod, protocol string, port uint16, path string) string {
+	return HostURL(pod.Status.PodIP, protocol, port, path)
+}
+
+// NewPodRequest builds a request to one of the pod's addresses, the host is
+// the address itself or a DNS name for it
+func NewPodRequest(ctx context.Context, pod *v1.Pod, ip string, host string, method, protocol string, port uint16, headers map[string]string, path string) (*PodRequest, error) {
+	url := HostURL(host, protocol, port, path)
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for headerName, headerValue := range headers {
+		req.Header.Add(headerName, headerValue)
+	}
+	return &PodRequest{
+		Pod:     pod,
+		Request: req,
+		IP:      ip,
+	}, nil
+}
+
+// unaddressedRequest stands in for the requests to a pod that cannot be
+// addressed, so the pod is reported as failed while the others are requested
+func unaddressedRequest(ctx context.Context, pod *v1.Pod, method, protocol string, port uint16, headers map[string]string, path string, addressErr error) ([]PodRequest, error) {
+	podRequest, err := NewPodRequest(ctx, pod, "", "", method, protocol, port, headers, path)
+	if err != nil {
+		return nil, err
+	}
+	podRequest.Err = addressErr
+	return []PodRequest{*podRequest}, nil
+}
+
+// PodRequests builds a request for each of the pod's addresses, errors are
+// only returned for requests that could not be built for any pod
+func PodRequests(ctx context.Context, pod *v1.Pod, addressing *PodAddressing, method, protocol string, port uint16, headers map[string]string, path string) ([]PodRequest, error) {
+	ips, err := requestIPs(pod, addressing.Family)
+	if err != nil {
+		return unaddressedRequest(ctx, pod, method, protocol, port, headers, path, err)
+	}
+	requests := []PodRequest{}
+	for _, ip := range ips {
+		host, err := addressing.Host(pod, ip)
+		if err != nil {
+			return unaddressedRequest(ctx, pod, method, protocol, port, headers, path, err)
+		}
+		podRequest, err := NewPodRequest(ctx, pod, ip, host, method, protocol, port, headers, path)
+		if err != nil {
+			return nil, err
+		}
+		if addressing.Family == IPFamilyBoth {
+			podRequest.Family = familyOf(ip)
+		}
+		requests = append(requests, *podRequest)
+	}
+	return requests, nil
+}
+
+// HttpRequests builds requests for every pod, a pod's requests are kept
+// together so each family is shown side by side
+func HttpRequests(ctx context.Context, pods *v1.PodList, addressing *PodAddressing, method, protocol string, port uint16, headers map[string]string, path string) ([]PodRequest, error) {
+	requests := []PodRequest{}
+	for _, pod := range pods.Items {
+		podRequests, err := PodRequests(ctx, &pod, addressing, method, protocol, port, headers, path)
+		if err != nil {
+			return nil, err
+		}
+		requests = append(requests, podRequests...)
+	}
+	return requests, nil
+}
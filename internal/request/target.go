@@ -0,0 +1,90 @@
+package request
+
+import (
+	"context"
+
+	"github.com/mini-ninja-64/flotilla/internal/kube"
+	v1 "k8s.io/api/core/v1"
+)
+
+// Target is the set of pods backing a service along with the port on those
+// pods that requests should be sent to
+type Target struct {
+	Pods    *v1.PodList
+	Service *v1.Service
+	Port    uint16
+}
+
+// ResolveTarget looks up the pods for a service, if the requested port is a
+// service port it is translated to the matching target port
+func ResolveTarget(ctx context.Context, kubeClient *kube.KubeClient, serviceName string, port uint16) (*Target, error) {
+	pods, service, err := kube.GetPodsForService(ctx, kubeClient, &serviceName)
+	if err != nil {
+		return nil, err
+	}
+	target := serviceTarget(service, port)
+	target.Pods = pods
+	return target, nil
+}
+
+// ResolveServiceTarget is like ResolveTarget but does not list the pods, for
+// callers that will follow the pods as they change instead
+func ResolveServiceTarget(ctx context.Context, kubeClient *kube.KubeClient, serviceName string, port uint16) (*Target, error) {
+	service, err := kube.GetService(ctx, kubeClient, &serviceName)
+	if err != nil {
+		return nil, err
+	}
+	return serviceTarget(service, port), nil
+}
+
+func serviceTarget(service *v1.Service, port uint16) *Target {
+	actualPort := port
+	for _, servicePort := range service.Spec.Ports {
+		if servicePort.Port == int32(port) {
+			actualPort = uint16(servicePort.TargetPort.IntVal)
+			break
+		}
+	}
+	return &Target{
+		Service: service,
+		Port:    actualPort,
+	}
+}
+
+// Requests builds a request for every pod in the target
+func (target *Target) Requests(ctx context.Context, args *Args) ([]PodRequest, error) {
+	return HttpRequests(
+		ctx,
+		target.Pods,
+		args.Addressing(target.Service),
+		args.Method,
+		args.Protocol,
+		target.Port,
+		args.Headers,
+		args.Path,
+	)
+}
+
+// PodRequests builds a request for each of a pod's addresses in the target
+func (target *Target) PodRequests(ctx context.Context, args *Args, pod *v1.Pod) ([]PodRequest, error) {
+	return PodRequests(
+		ctx,
+		pod,
+		args.Addressing(target.Service),
+		args.Method,
+		args.Protocol,
+		target.Port,
+		args.Headers,
+		args.Path,
+	)
+}
+
+// Request builds a single request for a pod in the target, with both address
+// families it is the first (IPv4) request
+func (target *Target) Request(ctx context.Context, args *Args, pod *v1.Pod) (*PodRequest, error) {
+	requests, err := target.PodRequests(ctx, args, pod)
+	if err != nil {
+		return nil, err
+	}
+	return &requests[0], nil
+}
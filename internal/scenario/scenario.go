@@ -0,0 +1,217 @@
+package scenario
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"text/template"
+
+	"github.com/mini-ninja-64/flotilla/internal/request"
+	"github.com/mini-ninja-64/flotilla/internal/util"
+	v1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// Scenario is a list of steps that are run in order against a single pod,
+// values extracted from one step's response can be used in later steps
+//
+//	steps:
+//	  - name: login
+//	    method: POST
+//	    path: /login
+//	    body: '{"user": "admin"}'
+//	    extract:
+//	      token: {jsonpath: .token}
+//	  - name: restart
+//	    method: POST
+//	    path: /admin/restart
+//	    headers:
+//	      Authorization: Bearer {{ .token }}
+type Scenario struct {
+	Steps []Step `json:"steps"`
+}
+
+// Step is a single request, Path, Headers and Body are templates that have
+// access to previously extracted values as well as .pod, .podIP and .namespace
+type Step struct {
+	Name         string               `json:"name"`
+	Method       string               `json:"method,omitempty"`
+	Path         string               `json:"path"`
+	Headers      map[string]string    `json:"headers,omitempty"`
+	Body         string               `json:"body,omitempty"`
+	ExpectStatus []int                `json:"expectStatus,omitempty"`
+	Extract      map[string]Extractor `json:"extract,omitempty"`
+}
+
+// Extractor pulls a single value out of a response, exactly one of the
+// fields must be set
+type Extractor struct {
+	JSONPath string `json:"jsonpath,omitempty"`
+	Regex    string `json:"regex,omitempty"`
+	Header   string `json:"header,omitempty"`
+}
+
+func Load(path string) (*Scenario, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	scenario := &Scenario{}
+	if err := yaml.UnmarshalStrict(contents, scenario); err != nil {
+		return nil, fmt.Errorf("Unable to parse scenario '%s': %w", path, err)
+	}
+	if len(scenario.Steps) == 0 {
+		return nil, fmt.Errorf("Scenario '%s' has no steps", path)
+	}
+	for i := range scenario.Steps {
+		step := &scenario.Steps[i]
+		if step.Name == "" {
+			step.Name = fmt.Sprintf("step %d", i+1)
+		}
+		if step.Method == "" {
+			step.Method = http.MethodGet
+		}
+		for name, extractor := range step.Extract {
+			if err := extractor.validate(); err != nil {
+				return nil, fmt.Errorf("Step '%s' extract '%s': %w", step.Name, name, err)
+			}
+		}
+	}
+	return scenario, nil
+}
+
+func (extractor Extractor) validate() error {
+	set := 0
+	for _, field := range []string{extractor.JSONPath, extractor.Regex, extractor.Header} {
+		if field != "" {
+			set++
+		}
+	}
+	if set != 1 {
+		return fmt.Errorf("Exactly one of jsonpath, regex or header must be set")
+	}
+	if extractor.Regex != "" {
+		if _, err := regexp.Compile(extractor.Regex); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Extract returns the value matched by the extractor, for regular expressions
+// this is the first capture group if there is one
+func (extractor Extractor) Extract(response *http.Response, body []byte) (string, error) {
+	switch {
+	case extractor.JSONPath != "":
+		return util.JSONPathValue(body, extractor.JSONPath)
+	case extractor.Header != "":
+		value := response.Header.Get(extractor.Header)
+		if value == "" {
+			return "", fmt.Errorf("Response has no '%s' header", extractor.Header)
+		}
+		return value, nil
+	default:
+		match := regexp.MustCompile(extractor.Regex).FindSubmatch(body)
+		if match == nil {
+			return "", fmt.Errorf("Response does not match /%s/", extractor.Regex)
+		}
+		if len(match) > 1 {
+			return string(match[1]), nil
+		}
+		return string(match[0]), nil
+	}
+}
+
+// Variables are the values available to step templates
+type Variables map[string]string
+
+func NewVariables(pod *v1.Pod, ip string) Variables {
+	return Variables{
+		"pod":       pod.Name,
+		"podIP":     ip,
+		"namespace": pod.Namespace,
+	}
+}
+
+func (variables Variables) render(name string, text string) (string, error) {
+	if !strings.Contains(text, "{{") {
+		return text, nil
+	}
+	parsed, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var rendered bytes.Buffer
+	if err := parsed.Execute(&rendered, map[string]string(variables)); err != nil {
+		return "", err
+	}
+	return rendered.String(), nil
+}
+
+// Request renders the step's templates into a request for a pod, headers from
+// the command line are applied first so the step can override them
+func (step *Step) Request(ctx context.Context, pod *v1.Pod, ip string, host string, args *request.Args, port uint16, variables Variables) (*request.PodRequest, error) {
+	path, err := variables.render("path", step.Path)
+	if err != nil {
+		return nil, err
+	}
+	body, err := variables.render("body", step.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var bodyReader io.Reader
+	if body != "" {
+		bodyReader = strings.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, step.Method, request.HostURL(host, args.Protocol, port, path), bodyReader)
+	if err != nil {
+		return nil, err
+	}
+	for headerName, headerValue := range args.Headers {
+		req.Header.Set(headerName, headerValue)
+	}
+	for headerName, headerValue := range step.Headers {
+		headerValue, err := variables.render(headerName, headerValue)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set(headerName, headerValue)
+	}
+	return &request.PodRequest{
+		Pod:     pod,
+		Request: req,
+		IP:      ip,
+	}, nil
+}
+
+// Check verifies the response has an expected status and adds any extracted
+// values to the variables
+func (step *Step) Check(response *request.PodHttpResponse, variables Variables) error {
+	if response.Error != nil {
+		return response.Error
+	}
+	statusCode := response.Response.StatusCode
+	if len(step.ExpectStatus) > 0 {
+		if !slices.Contains(step.ExpectStatus, statusCode) {
+			return fmt.Errorf("Unexpected status: %s", response.Response.Status)
+		}
+	} else if statusCode < 200 || statusCode >= 300 {
+		return fmt.Errorf("Unexpected status: %s", response.Response.Status)
+	}
+
+	for name, extractor := range step.Extract {
+		value, err := extractor.Extract(response.Response, response.Body)
+		if err != nil {
+			return fmt.Errorf("Unable to extract '%s': %w", name, err)
+		}
+		variables[name] = value
+	}
+	return nil
+}
@@ -0,0 +1,205 @@
+package scenario
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mini-ninja-64/flotilla/internal/request"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func writeScenario(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "scenario.yaml")
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	scenario, err := Load(writeScenario(t, `
+steps:
+  - path: /health
+  - name: login
+    method: POST
+    path: /login
+    extract:
+      token: {jsonpath: .token}
+      session: {header: Set-Cookie}
+      id: {regex: 'id=(\d+)'}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scenario.Steps) != 2 {
+		t.Fatalf("expected 2 steps, got %d", len(scenario.Steps))
+	}
+	if step := scenario.Steps[0]; step.Name != "step 1" || step.Method != http.MethodGet {
+		t.Errorf("expected defaults for the first step, got %q %q", step.Name, step.Method)
+	}
+	if len(scenario.Steps[1].Extract) != 3 {
+		t.Errorf("expected 3 extractors, got %v", scenario.Steps[1].Extract)
+	}
+}
+
+func TestLoadRejectsBadScenarios(t *testing.T) {
+	tests := map[string]string{
+		"no steps":        "steps: []",
+		"unknown field":   "steps:\n  - path: /\n    paht: /typo",
+		"not yaml":        "steps: [",
+		"no extractor":    "steps:\n  - path: /\n    extract:\n      token: {}",
+		"two extractors":  "steps:\n  - path: /\n    extract:\n      token: {jsonpath: .token, header: X-Token}",
+		"invalid pattern": "steps:\n  - path: /\n    extract:\n      token: {regex: '('}",
+	}
+	for name, contents := range tests {
+		if _, err := Load(writeScenario(t, contents)); err == nil {
+			t.Errorf("%s: expected the scenario to be rejected", name)
+		}
+	}
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Error("expected a missing file to be rejected")
+	}
+}
+
+func TestExtract(t *testing.T) {
+	response := &http.Response{Header: http.Header{"X-Token": {"abc"}}}
+	body := []byte(`{"token":"abc","user":{"id":7}}`)
+	text := []byte("session id=42 ok")
+
+	tests := []struct {
+		name      string
+		extractor Extractor
+		body      []byte
+		want      string
+	}{
+		{"jsonpath", Extractor{JSONPath: ".user.id"}, body, "7"},
+		{"jsonpath braces", Extractor{JSONPath: "{.token}"}, body, "abc"},
+		{"header", Extractor{Header: "x-token"}, body, "abc"},
+		{"regex group", Extractor{Regex: `id=(\d+)`}, text, "42"},
+		{"regex match", Extractor{Regex: `id=\d+`}, text, "id=42"},
+	}
+	for _, test := range tests {
+		value, err := test.extractor.Extract(response, test.body)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if value != test.want {
+			t.Errorf("%s: expected %q, got %q", test.name, test.want, value)
+		}
+	}
+
+	failures := map[string]Extractor{
+		"missing header":   {Header: "X-Missing"},
+		"regex mismatch":   {Regex: `token=(\w+)`},
+		"jsonpath missing": {JSONPath: ".missing"},
+	}
+	for name, extractor := range failures {
+		if _, err := extractor.Extract(response, body); err == nil {
+			t.Errorf("%s: expected extraction to fail", name)
+		}
+	}
+}
+
+func testPod() *v1.Pod {
+	return &v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "web-0"}}
+}
+
+func TestRequestRendersTemplates(t *testing.T) {
+	step := &Step{
+		Method:  http.MethodPost,
+		Path:    "/users/{{ .user }}?pod={{ .pod }}",
+		Headers: map[string]string{"Authorization": "Bearer {{ .token }}", "X-Static": "step"},
+		Body:    `{"namespace": "{{ .namespace }}", "ip": "{{ .podIP }}"}`,
+	}
+	variables := NewVariables(testPod(), "10.0.0.1")
+	variables["user"] = "7"
+	variables["token"] = "abc"
+	args := &request.Args{Protocol: "http", Headers: map[string]string{"X-Static": "args", "X-Args": "1"}}
+
+	podRequest, err := step.Request(context.Background(), testPod(), "10.0.0.1", "10.0.0.1", args, 8080, variables)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := podRequest.Request
+	if want := "http://10.0.0.1:8080/users/7?pod=web-0"; req.URL.String() != want {
+		t.Errorf("expected %s, got %s", want, req.URL)
+	}
+	if req.Header.Get("Authorization") != "Bearer abc" {
+		t.Errorf("expected a rendered header, got %q", req.Header.Get("Authorization"))
+	}
+	if req.Header.Get("X-Static") != "step" || req.Header.Get("X-Args") != "1" {
+		t.Errorf("expected step headers to override the command line, got %v", req.Header)
+	}
+	body, _ := io.ReadAll(req.Body)
+	if want := `{"namespace": "shop", "ip": "10.0.0.1"}`; string(body) != want {
+		t.Errorf("expected body %s, got %s", want, body)
+	}
+}
+
+func TestRequestRejectsMissingVariables(t *testing.T) {
+	steps := map[string]*Step{
+		"path":   {Method: http.MethodGet, Path: "/{{ .missing }}"},
+		"body":   {Method: http.MethodGet, Path: "/", Body: "{{ .missing }}"},
+		"header": {Method: http.MethodGet, Path: "/", Headers: map[string]string{"X-Value": "{{ .missing }}"}},
+		"syntax": {Method: http.MethodGet, Path: "/{{ .pod "},
+	}
+	for name, step := range steps {
+		_, err := step.Request(context.Background(), testPod(), "10.0.0.1", "10.0.0.1", &request.Args{Protocol: "http"}, 8080, NewVariables(testPod(), "10.0.0.1"))
+		if err == nil {
+			t.Errorf("%s: expected the step to be rejected", name)
+		}
+	}
+}
+
+func podResponse(status int, body string) *request.PodHttpResponse {
+	return &request.PodHttpResponse{
+		Response: &http.Response{StatusCode: status, Status: http.StatusText(status), Header: http.Header{}},
+		Body:     []byte(body),
+	}
+}
+
+func TestCheck(t *testing.T) {
+	step := &Step{Extract: map[string]Extractor{"token": {JSONPath: ".token"}}}
+	variables := Variables{}
+	if err := step.Check(podResponse(http.StatusOK, `{"token":"abc"}`), variables); err != nil {
+		t.Fatal(err)
+	}
+	if variables["token"] != "abc" {
+		t.Errorf("expected the token to be extracted, got %v", variables)
+	}
+
+	tests := []struct {
+		name     string
+		step     *Step
+		response *request.PodHttpResponse
+		passes   bool
+	}{
+		{"redirect by default", &Step{}, podResponse(http.StatusFound, ""), false},
+		{"expected status", &Step{ExpectStatus: []int{404}}, podResponse(http.StatusNotFound, ""), true},
+		{"unexpected success", &Step{ExpectStatus: []int{404}}, podResponse(http.StatusOK, ""), false},
+		{"failed extraction", step, podResponse(http.StatusOK, `{}`), false},
+		{"request error", &Step{}, &request.PodHttpResponse{Error: errors.New("connection refused")}, false},
+	}
+	for _, test := range tests {
+		err := test.step.Check(test.response, Variables{})
+		if (err == nil) != test.passes {
+			t.Errorf("%s: expected passing to be %t, got %v", test.name, test.passes, err)
+		}
+	}
+}
+
+func TestRenderLeavesPlainText(t *testing.T) {
+	rendered, err := Variables{}.render("path", "/no/templates/{here}")
+	if err != nil || !strings.HasSuffix(rendered, "{here}") {
+		t.Errorf("expected text without templates to be unchanged, got %q, %v", rendered, err)
+	}
+}
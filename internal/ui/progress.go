@@ -1,12 +1,14 @@
 package ui
 
 import (
+	"os"
 	"strings"
 	"sync"
 	"time"
 	"weak"
 
 	"github.com/charmbracelet/bubbles/progress"
+	"github.com/mini-ninja-64/flotilla/internal/kube"
 
 	tea "github.com/charmbracelet/bubbletea"
 )
@@ -18,37 +20,82 @@ type ProgressTrackers struct {
 	model   *Model
 	program *tea.Program
 
+	mu       sync.Mutex
+	running  bool
+	barCount uint64
+
 	wg  sync.WaitGroup
 	err error
 }
 
-func NewProgressTrackers() *ProgressTrackers {
+// StdoutIsTerminal reports whether the live view can be drawn, when stdout is
+// piped or redirected it is left for the command's own output
+func StdoutIsTerminal() bool {
+	info, err := os.Stdout.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// interruptFilter turns the first SIGINT into ctrl+c, without a renderer the
+// terminal is not in raw mode so ctrl+c arrives as a signal and would
+// otherwise quit before in flight work is cancelled
+func interruptFilter(model tea.Model, msg tea.Msg) tea.Msg {
+	if _, ok := msg.(tea.InterruptMsg); ok {
+		if m, ok := model.(*Model); ok && !m.interrupted {
+			return tea.KeyMsg{Type: tea.KeyCtrlC}
+		}
+	}
+	return msg
+}
+
+// NewProgressTrackers creates a set of trackers, onInterrupt is called the
+// first time the user presses ctrl+c so in flight work can be cancelled and
+// reported, a second ctrl+c will exit immediately. The live view is only drawn
+// when stdout is a terminal, the final view is still printed to stderr
+func NewProgressTrackers(onInterrupt func()) *ProgressTrackers {
 	model := &Model{
 		progressBars: []*ProgressBar{},
 		refreshRate:  time.Millisecond * 50,
+		onInterrupt:  onInterrupt,
 	}
-	program := tea.NewProgram(model)
+	options := []tea.ProgramOption{}
+	if !StdoutIsTerminal() {
+		options = append(options, tea.WithoutRenderer(), tea.WithInput(nil), tea.WithFilter(interruptFilter))
+	}
+	program := tea.NewProgram(model, options...)
 	return &ProgressTrackers{
 		program: program,
 		model:   model,
 	}
 }
 
+// AddProgressBar can be called before or after RunAsync, bars added while
+// running are handed to the program so they appear in order
 func (p *ProgressTrackers) AddProgressBar(title string, subtitle string) *ProgressBar {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 	progressBar := &ProgressBar{
 		title:    title,
 		subtitle: subtitle,
 		model:    progress.New(progress.WithDefaultGradient(), progress.WithSpringOptions(50, 1)),
-		index:    uint64(len(p.model.progressBars)),
+		index:    p.barCount,
 		program:  weak.Make(p.program),
 		state:    Unknown,
 	}
+	p.barCount++
 
-	p.model.progressBars = append(p.model.progressBars, progressBar)
+	if p.running {
+		p.program.Send(AddTracker{progressBar})
+	} else {
+		p.model.progressBars = append(p.model.progressBars, progressBar)
+	}
 	return progressBar
 }
 
 func (bars *ProgressTrackers) RunAsync() {
+	bars.mu.Lock()
+	bars.running = true
+	bars.mu.Unlock()
+
 	bars.wg.Add(1)
 	go func() {
 		_, err := bars.program.Run()
@@ -63,16 +110,35 @@ func (bars *ProgressTrackers) Wait() error {
 	return bars.err
 }
 
+// LogChange records a notable event, the most recent changes are shown
+// beneath the trackers
+func (bars *ProgressTrackers) LogChange(change string) {
+	bars.program.Send(AppendChangeLog(change))
+}
+
+// LogTunnelEvent records a port forward tunnel dropping or reconnecting
+func (bars *ProgressTrackers) LogTunnelEvent(event kube.TunnelEvent) {
+	bars.LogChange(time.Now().Format(time.TimeOnly) + " " + event.String())
+}
+
 func (bars *ProgressTrackers) Finish() {
 	bars.program.Send(Completed{})
 }
 
+// Only the most recent changes are shown while running, the whole (bounded)
+// log is printed once finished
+const liveChangeLogLength = 10
+const changeLogLength = 1000
+
 type Model struct {
 	progressBars []*ProgressBar
+	changeLog    []string
 
 	refreshRate time.Duration
 	completed   bool
 	quitting    bool
+	interrupted bool
+	onInterrupt func()
 }
 
 // Init implements tea.Model.
@@ -100,7 +166,7 @@ func (m *Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		}
 		cmds = append(cmds, tickCmd(m.refreshRate), m.progressBars[message.index].model.SetPercent(message.percentage))
 
-	case SetTrackerText, SetTrackerContent:
+	case SetTrackerText, SetTrackerContent, SetTrackerState, SetTrackerAttempt, SetTrackerStage:
 		if m.completed {
 			break
 		}
@@ -109,14 +175,50 @@ func (m *Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			m.progressBars[message.index].content = message.value
 		case SetTrackerText:
 			m.progressBars[message.index].text = message.value
+		case SetTrackerState:
+			m.progressBars[message.index].state = message.value
+		case SetTrackerAttempt:
+			m.progressBars[message.index].attempt = message.value
+		case SetTrackerStage:
+			m.progressBars[message.index].stage = message.value
 		}
 
 		cmds = append(cmds, tickCmd(m.refreshRate))
+	case AddTracker:
+		m.progressBars = append(m.progressBars, message.progressBar)
+		cmds = append(cmds, tickCmd(m.refreshRate))
+
+	case AppendTrackerHistory:
+		if m.completed {
+			break
+		}
+		progressBar := m.progressBars[message.index]
+		progressBar.history = append(progressBar.history, message.value)
+		if len(progressBar.history) > historyLength {
+			progressBar.history = progressBar.history[len(progressBar.history)-historyLength:]
+		}
+		cmds = append(cmds, tickCmd(m.refreshRate))
+
+	case AppendChangeLog:
+		if m.completed {
+			break
+		}
+		m.changeLog = append(m.changeLog, string(message))
+		if len(m.changeLog) > changeLogLength {
+			m.changeLog = m.changeLog[len(m.changeLog)-changeLogLength:]
+		}
+		cmds = append(cmds, tickCmd(m.refreshRate))
+
 	case tea.ResumeMsg:
 		// m.suspending = false
 	case tea.KeyMsg:
 		switch message.String() {
 		case "ctrl+c":
+			if !m.interrupted && m.onInterrupt != nil {
+				m.interrupted = true
+				m.onInterrupt()
+				break
+			}
 			// TODO: do i need to set quitting true
 			cmds = append(cmds, tea.Interrupt)
 		case "ctrl+z":
@@ -167,6 +269,16 @@ func (m *Model) FilteredView(printNothingOnQuit bool) string {
 	for _, progressBar := range m.progressBars {
 		view += progressBar.View(pad) + "\n"
 	}
+	if len(m.changeLog) > 0 {
+		changeLog := m.changeLog
+		if printNothingOnQuit && len(changeLog) > liveChangeLogLength {
+			changeLog = changeLog[len(changeLog)-liveChangeLogLength:]
+		}
+		view += pad + titleStyle("Changes:") + "\n"
+		for _, change := range changeLog {
+			view += pad + pad + changedStyle(change) + "\n"
+		}
+	}
 	return view
 }
 
@@ -179,6 +291,14 @@ type SetBarPercentage struct {
 }
 type SetTrackerContent SetTrackerProperty[string]
 type SetTrackerText SetTrackerProperty[string]
+type SetTrackerState SetTrackerProperty[ProgressState]
+type SetTrackerAttempt SetTrackerProperty[string]
+type SetTrackerStage SetTrackerProperty[string]
+type AppendTrackerHistory SetTrackerProperty[HistoryEntry]
+type AppendChangeLog string
+type AddTracker struct {
+	progressBar *ProgressBar
+}
 type SetTrackerProperty[T any] struct {
 	index uint64
 	value T
@@ -1,11 +1,18 @@
 package ui
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
 	"weak"
 
 	"github.com/charmbracelet/bubbles/progress"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+	"github.com/mini-ninja-64/flotilla/internal/kube"
+	"github.com/mini-ninja-64/flotilla/internal/util"
 )
 
 type ProgressState int
@@ -14,15 +21,33 @@ const (
 	Unknown ProgressState = iota
 	Success
 	Failure
+	Queued
+	Cancelled
+	TimedOut
+	Gone
+	Unreachable
 )
 
+const historyLength = 30
+
+// HistoryEntry is the outcome of one round of requests to a pod, Changed
+// marks an outcome that differs from the previous round
+type HistoryEntry struct {
+	State   ProgressState
+	Latency time.Duration
+	Changed bool
+}
+
 type ProgressBar struct {
 	model    progress.Model
 	title    string
 	subtitle string
 	text     string
 	content  string
+	attempt  string
+	stage    string
 	state    ProgressState
+	history  []HistoryEntry
 
 	index   uint64
 	program weak.Pointer[tea.Program]
@@ -45,12 +70,35 @@ var failureStyle = lipgloss.NewStyle().
 	Foreground(lipgloss.AdaptiveColor{Light: "#dc0000ff", Dark: "#dc0000ff"}).
 	Render
 
+var queuedStyle = lipgloss.NewStyle().
+	Foreground(lipgloss.AdaptiveColor{Light: "#606060ff", Dark: "#979797ff"}).
+	Italic(true).
+	Render
+
+var changedStyle = lipgloss.NewStyle().
+	Bold(true).
+	Render
+
+var timedOutStyle = lipgloss.NewStyle().
+	Foreground(lipgloss.AdaptiveColor{Light: "#d78700ff", Dark: "#ffaf00ff"}).
+	Render
+
+var unreachableStyle = lipgloss.NewStyle().
+	Foreground(lipgloss.AdaptiveColor{Light: "#af00afff", Dark: "#d75fd7ff"}).
+	Render
+
 func (state ProgressState) style(text string) string {
 	switch state {
 	case Success:
 		return successStyle(text)
 	case Failure:
 		return failureStyle(text)
+	case Queued, Cancelled, Gone:
+		return queuedStyle(text)
+	case TimedOut:
+		return timedOutStyle(text)
+	case Unreachable:
+		return unreachableStyle(text)
 	case Unknown:
 		return text
 	}
@@ -58,8 +106,20 @@ func (state ProgressState) style(text string) string {
 }
 
 func (progressBar *ProgressBar) View(pad string) string {
-	view := pad + titleStyle(progressBar.title) + " " + subtitleStyle(progressBar.subtitle) + titleStyle(":") + "\n" +
-		pad + pad + progressBar.model.View() + " " + progressBar.state.style(progressBar.text) + "\n"
+	stage := ""
+	if progressBar.stage != "" {
+		stage = " " + progressBar.stage
+	}
+	view := pad + titleStyle(progressBar.title) + " " + subtitleStyle(progressBar.subtitle) + stage + titleStyle(":") + "\n" +
+		pad + pad + progressBar.model.View() + " " + progressBar.state.style(progressBar.text)
+	if progressBar.attempt != "" {
+		view += " " + subtitleStyle(progressBar.attempt)
+	}
+	view += "\n"
+
+	if len(progressBar.history) > 0 {
+		view += pad + pad + progressBar.historyView() + "\n"
+	}
 
 	if progressBar.content != "" {
 		contentStyle := lipgloss.NewStyle().
@@ -69,6 +129,21 @@ func (progressBar *ProgressBar) View(pad string) string {
 	}
 	return view
 }
+func (progressBar *ProgressBar) historyView() string {
+	var strip strings.Builder
+	latencies := make([]uint64, len(progressBar.history))
+	for i, entry := range progressBar.history {
+		cell := "█"
+		if entry.Changed {
+			cell = changedStyle("◆")
+		}
+		strip.WriteString(entry.State.style(cell))
+		latencies[i] = uint64(entry.Latency.Microseconds())
+	}
+	latest := progressBar.history[len(progressBar.history)-1]
+	return strip.String() + "  " + subtitleStyle(Sparkline(latencies)+" "+latest.Latency.Round(time.Millisecond).String())
+}
+
 func (progressBar *ProgressBar) SetText(text string) error {
 	progressBar.program.Value().Send(SetTrackerText{
 		index: progressBar.index,
@@ -85,6 +160,34 @@ func (progressBar *ProgressBar) SetContent(content string) error {
 	return nil
 }
 
+// SetAttempt shows which attempt is in progress, nothing is shown when only a
+// single attempt will be made
+func (progressBar *ProgressBar) SetAttempt(attempt uint, maxAttempts uint) error {
+	if maxAttempts <= 1 {
+		return nil
+	}
+	progressBar.program.Value().Send(SetTrackerAttempt{
+		index: progressBar.index,
+		value: fmt.Sprintf("(attempt %d/%d)", attempt, maxAttempts),
+	})
+	return nil
+}
+
+func (progressBar *ProgressBar) AddHistory(entry HistoryEntry) {
+	progressBar.program.Value().Send(AppendTrackerHistory{
+		index: progressBar.index,
+		value: entry,
+	})
+}
+
+// SetStage shows which step of a multi step process is in progress
+func (progressBar *ProgressBar) SetStage(stage uint, stageCount uint, name string) {
+	progressBar.program.Value().Send(SetTrackerStage{
+		index: progressBar.index,
+		value: fmt.Sprintf("[%d/%d %s]", stage, stageCount, name),
+	})
+}
+
 func (progressBar *ProgressBar) SetPercentage(percentage float64) error {
 	if percentage > 1 {
 		percentage = 1.0
@@ -99,5 +202,31 @@ func (progressBar *ProgressBar) SetPercentage(percentage float64) error {
 }
 
 func (progressBar *ProgressBar) SetProgressState(state ProgressState) {
-	progressBar.state = state
+	progressBar.program.Value().Send(SetTrackerState{
+		index: progressBar.index,
+		value: state,
+	})
+}
+
+// ErrorState picks how an error should be shown, cancellations, timeouts and
+// port forward failures are distinguished from other failures. A connect
+// timeout is not the request timing out, it is shown as the connect failing
+func ErrorState(err error) (ProgressState, string) {
+	var tunnelErr *kube.TunnelError
+	switch {
+	case errors.Is(err, context.DeadlineExceeded) && !errors.Is(err, util.ErrConnectTimeout):
+		return TimedOut, "timed out"
+	case errors.Is(err, context.Canceled):
+		return Cancelled, "cancelled"
+	case errors.As(err, &tunnelErr):
+		return Unreachable, "tunnel: " + tunnelErr.Err.Error()
+	default:
+		return Failure, err.Error()
+	}
+}
+
+func (progressBar *ProgressBar) SetError(err error) {
+	state, text := ErrorState(err)
+	progressBar.SetProgressState(state)
+	progressBar.SetText(text)
 }
@@ -0,0 +1,27 @@
+package ui
+
+import (
+	"slices"
+	"strings"
+)
+
+var sparkBlocks = []rune("▁▂▃▄▅▆▇█")
+
+// Sparkline renders values as a single line of block characters scaled
+// relative to the largest value
+func Sparkline(values []uint64) string {
+	if len(values) == 0 {
+		return ""
+	}
+	largest := slices.Max(values)
+	var line strings.Builder
+	for _, value := range values {
+		if largest == 0 {
+			line.WriteRune(sparkBlocks[0])
+			continue
+		}
+		level := int(value * uint64(len(sparkBlocks)-1) / largest)
+		line.WriteRune(sparkBlocks[level])
+	}
+	return line.String()
+}
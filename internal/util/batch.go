@@ -0,0 +1,25 @@
+package util
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ParseBatchSize turns a batch size (either a count or a percentage) into a
+// number of items per batch, a percentage always produces at least 1
+func ParseBatchSize(batchSize string, total int) (int, error) {
+	if percentage, isPercentage := strings.CutSuffix(batchSize, "%"); isPercentage {
+		value, err := strconv.ParseFloat(percentage, 64)
+		if err != nil || value <= 0 || value > 100 {
+			return 0, fmt.Errorf("Invalid batch size '%s', percentages must be between 0 and 100", batchSize)
+		}
+		return max(int(math.Ceil(float64(total)*value/100)), 1), nil
+	}
+	count, err := strconv.Atoi(batchSize)
+	if err != nil || count <= 0 {
+		return 0, fmt.Errorf("Invalid batch size '%s', expected a positive number or a percentage", batchSize)
+	}
+	return count, nil
+}
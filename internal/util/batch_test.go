@@ -0,0 +1,34 @@
+package util
+
+import "testing"
+
+func TestParseBatchSize(t *testing.T) {
+	tests := []struct {
+		batchSize string
+		total     int
+		want      int
+	}{
+		{"3", 10, 3},
+		{"20", 10, 20},
+		{"10%", 25, 3},
+		{"100%", 7, 7},
+		{"1%", 5, 1},
+		{"50%", 0, 1},
+	}
+	for _, test := range tests {
+		got, err := ParseBatchSize(test.batchSize, test.total)
+		if err != nil {
+			t.Errorf("ParseBatchSize(%q, %d) failed: %v", test.batchSize, test.total, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("ParseBatchSize(%q, %d) = %d, want %d", test.batchSize, test.total, got, test.want)
+		}
+	}
+
+	for _, batchSize := range []string{"0", "-1", "a", "0%", "101%", "%"} {
+		if _, err := ParseBatchSize(batchSize, 10); err == nil {
+			t.Errorf("expected '%s' to be rejected", batchSize)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrConnectTimeout is a connect that took longer than --connect-timeout, it
+// is a connect error rather than the request timing out
+var ErrConnectTimeout = errors.New("connect timed out")
+
+// WithOptionalTimeout behaves like context.WithTimeout, except a timeout of
+// zero means no timeout is applied
+func WithOptionalTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// ConnectTimeout marks the deadline error of a connect that ran out of time
+// while the request's own context was still live, so it can be told apart
+// from the request timing out. The original error is still wrapped so a
+// tunnel failure can be found with errors.As
+func ConnectTimeout(ctx context.Context, err error) error {
+	if err != nil && ctx.Err() == nil && errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("%w: %w", ErrConnectTimeout, err)
+	}
+	return err
+}
@@ -0,0 +1,47 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mini-ninja-64/flotilla/internal/kube"
+)
+
+func TestConnectTimeout(t *testing.T) {
+	dialer := &net.Dialer{Timeout: time.Nanosecond}
+	_, dialErr := dialer.DialContext(context.Background(), "tcp", "192.0.2.1:80")
+	if !errors.Is(dialErr, context.DeadlineExceeded) {
+		t.Skipf("dial did not time out: %v", dialErr)
+	}
+
+	err := ConnectTimeout(context.Background(), dialErr)
+	if !errors.Is(err, ErrConnectTimeout) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected a connect timeout wrapping the deadline, got %v", err)
+	}
+
+	expired, cancel := context.WithTimeout(context.Background(), 0)
+	defer cancel()
+	<-expired.Done()
+	if err := ConnectTimeout(expired, expired.Err()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the request's own timeout to be kept, got %v", err)
+	}
+
+	other := errors.New("connection refused")
+	if err := ConnectTimeout(context.Background(), other); err != other {
+		t.Errorf("expected other errors to be kept, got %v", err)
+	}
+}
+
+func TestConnectTimeoutKeepsTunnelErrors(t *testing.T) {
+	err := ConnectTimeout(context.Background(), &kube.TunnelError{Pod: "pod-0", Port: 8080, Err: context.DeadlineExceeded})
+	var tunnelErr *kube.TunnelError
+	if !errors.Is(err, ErrConnectTimeout) || !errors.As(err, &tunnelErr) {
+		t.Fatalf("expected a connect timeout wrapping the tunnel error, got %v", err)
+	}
+	if tunnelErr.Pod != "pod-0" {
+		t.Errorf("expected the tunnel error for pod-0, got %s", tunnelErr.Pod)
+	}
+}
@@ -0,0 +1,21 @@
+package util
+
+const (
+	ExitRequestFailed = 2
+	ExitTunnelFailed  = 3
+)
+
+// ExitError is returned by commands that need a specific exit code, so
+// scripts can tell why a run failed
+type ExitError struct {
+	Code int
+	Err  error
+}
+
+func (err *ExitError) Error() string {
+	return err.Err.Error()
+}
+
+func (err *ExitError) Unwrap() error {
+	return err.Err
+}
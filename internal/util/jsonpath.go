@@ -0,0 +1,74 @@
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"k8s.io/client-go/util/jsonpath"
+)
+
+// JSONPathValue evaluates a kubectl style JSONPath expression against a JSON
+// document, the braces around the expression are optional
+func JSONPathValue(document []byte, expression string) (string, error) {
+	if !strings.HasPrefix(expression, "{") {
+		expression = "{" + expression + "}"
+	}
+	path := jsonpath.New("flotilla")
+	if err := path.Parse(expression); err != nil {
+		return "", err
+	}
+
+	var data any
+	if err := json.Unmarshal(document, &data); err != nil {
+		return "", fmt.Errorf("Response is not valid JSON: %w", err)
+	}
+	var value bytes.Buffer
+	if err := path.Execute(&value, data); err != nil {
+		return "", err
+	}
+	return value.String(), nil
+}
+
+// Expectation is a JSONPath expression and the value it should produce
+type Expectation struct {
+	Expression string
+	Expected   string
+}
+
+// ParseExpectation parses an expectation in the form expression=value, the
+// first '=' outside of brackets separates the two so filters can be used
+func ParseExpectation(expectation string) (*Expectation, error) {
+	depth := 0
+	for i, char := range expectation {
+		switch char {
+		case '[', '(', '{':
+			depth++
+		case ']', ')', '}':
+			depth--
+		case '=':
+			if depth == 0 {
+				return &Expectation{
+					Expression: expectation[:i],
+					Expected:   expectation[i+1:],
+				}, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("Expectation '%s' must be in the form expression=value", expectation)
+}
+
+// Check evaluates the expectation against a JSON document, returning the
+// actual value found
+func (expectation *Expectation) Check(document []byte) (string, bool, error) {
+	actual, err := JSONPathValue(document, expectation.Expression)
+	if err != nil {
+		return "", false, err
+	}
+	return actual, actual == expectation.Expected, nil
+}
+
+func (expectation *Expectation) String() string {
+	return expectation.Expression + "=" + expectation.Expected
+}
@@ -0,0 +1,62 @@
+package util
+
+import "testing"
+
+func TestParseExpectation(t *testing.T) {
+	tests := []struct {
+		expectation string
+		expression  string
+		expected    string
+	}{
+		{".status=ok", ".status", "ok"},
+		{".version=", ".version", ""},
+		{`.items[?(@.name=="db")].state=up`, `.items[?(@.name=="db")].state`, "up"},
+		{"{.a}=b=c", "{.a}", "b=c"},
+	}
+	for _, test := range tests {
+		expectation, err := ParseExpectation(test.expectation)
+		if err != nil {
+			t.Errorf("ParseExpectation(%q) failed: %v", test.expectation, err)
+			continue
+		}
+		if expectation.Expression != test.expression || expectation.Expected != test.expected {
+			t.Errorf("ParseExpectation(%q) = %q, %q, want %q, %q", test.expectation, expectation.Expression, expectation.Expected, test.expression, test.expected)
+		}
+	}
+
+	for _, expectation := range []string{".status", `.items[?(@.name=="db")]`} {
+		if _, err := ParseExpectation(expectation); err == nil {
+			t.Errorf("expected '%s' to be rejected", expectation)
+		}
+	}
+}
+
+func TestExpectationCheck(t *testing.T) {
+	document := []byte(`{"status":"ok","items":[{"name":"db","state":"up"},{"name":"cache","state":"down"}]}`)
+	tests := map[string]bool{
+		".status=ok":                          true,
+		"{.status}=ok":                        true,
+		".status=degraded":                    false,
+		`.items[?(@.name=="db")].state=up`:    true,
+		`.items[?(@.name=="cache")].state=up`: false,
+	}
+	for value, want := range tests {
+		expectation, err := ParseExpectation(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, matched, err := expectation.Check(document)
+		if err != nil {
+			t.Errorf("%s: %v", value, err)
+			continue
+		}
+		if matched != want {
+			t.Errorf("%s: matched = %t, want %t", value, matched, want)
+		}
+	}
+
+	expectation := &Expectation{Expression: ".status", Expected: "ok"}
+	if _, _, err := expectation.Check([]byte("not json")); err == nil {
+		t.Error("expected a body that is not JSON to fail")
+	}
+}
@@ -0,0 +1,137 @@
+package util
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// LatencyRecorder collects request latencies from many goroutines, only
+// successful requests contribute to the latency percentiles
+type LatencyRecorder struct {
+	mu      sync.Mutex
+	samples []time.Duration
+	errors  uint64
+	start   time.Time
+	end     time.Time
+}
+
+type LatencySummary struct {
+	Count      uint64
+	Errors     uint64
+	Min        time.Duration
+	P50        time.Duration
+	P90        time.Duration
+	P99        time.Duration
+	Max        time.Duration
+	Elapsed    time.Duration
+	Throughput float64
+	ErrorRate  float64
+}
+
+func NewLatencyRecorder() *LatencyRecorder {
+	return &LatencyRecorder{
+		start: time.Now(),
+	}
+}
+
+func (recorder *LatencyRecorder) Record(latency time.Duration, failed bool) {
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	if failed {
+		recorder.errors++
+		return
+	}
+	recorder.samples = append(recorder.samples, latency)
+}
+
+// Stop freezes the elapsed time used to calculate throughput
+func (recorder *LatencyRecorder) Stop() {
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	recorder.end = time.Now()
+}
+
+func (recorder *LatencyRecorder) elapsed() time.Duration {
+	if recorder.end.IsZero() {
+		return time.Since(recorder.start)
+	}
+	return recorder.end.Sub(recorder.start)
+}
+
+func (recorder *LatencyRecorder) Summary() LatencySummary {
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	return summarise(slices.Clone(recorder.samples), recorder.errors, recorder.elapsed())
+}
+
+// Histogram buckets the recorded latencies linearly between the fastest and
+// slowest request
+func (recorder *LatencyRecorder) Histogram(bucketCount int) []uint64 {
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	buckets := make([]uint64, bucketCount)
+	if len(recorder.samples) == 0 || bucketCount == 0 {
+		return buckets
+	}
+	fastest := slices.Min(recorder.samples)
+	slowest := slices.Max(recorder.samples)
+	width := float64(slowest-fastest) / float64(bucketCount)
+	for _, sample := range recorder.samples {
+		bucket := bucketCount - 1
+		if width > 0 {
+			bucket = min(int(float64(sample-fastest)/width), bucketCount-1)
+		}
+		buckets[bucket]++
+	}
+	return buckets
+}
+
+// MergeLatencies summarises the samples of several recorders as if they were
+// one, throughput is measured over the longest running recorder
+func MergeLatencies(recorders ...*LatencyRecorder) LatencySummary {
+	samples := []time.Duration{}
+	var errors uint64
+	var elapsed time.Duration
+	for _, recorder := range recorders {
+		recorder.mu.Lock()
+		samples = append(samples, recorder.samples...)
+		errors += recorder.errors
+		elapsed = max(elapsed, recorder.elapsed())
+		recorder.mu.Unlock()
+	}
+	return summarise(samples, errors, elapsed)
+}
+
+func summarise(samples []time.Duration, errors uint64, elapsed time.Duration) LatencySummary {
+	slices.Sort(samples)
+	count := uint64(len(samples)) + errors
+	summary := LatencySummary{
+		Count:   count,
+		Errors:  errors,
+		P50:     percentile(samples, 0.50),
+		P90:     percentile(samples, 0.90),
+		P99:     percentile(samples, 0.99),
+		Elapsed: elapsed,
+	}
+	if len(samples) > 0 {
+		summary.Min = samples[0]
+		summary.Max = samples[len(samples)-1]
+	}
+	if count > 0 {
+		summary.ErrorRate = float64(errors) / float64(count)
+	}
+	if elapsed > 0 {
+		summary.Throughput = float64(count) / elapsed.Seconds()
+	}
+	return summary
+}
+
+// percentile uses the nearest rank method, samples must already be sorted
+func percentile(samples []time.Duration, quantile float64) time.Duration {
+	if len(samples) == 0 {
+		return 0
+	}
+	rank := int(quantile*float64(len(samples))+0.5) - 1
+	return samples[max(min(rank, len(samples)-1), 0)]
+}
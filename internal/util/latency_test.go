@@ -0,0 +1,50 @@
+package util
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPercentile(t *testing.T) {
+	samples := make([]time.Duration, 100)
+	for i := range samples {
+		samples[i] = time.Duration(i+1) * time.Millisecond
+	}
+	for quantile, want := range map[float64]time.Duration{
+		0.50: 50 * time.Millisecond,
+		0.90: 90 * time.Millisecond,
+		0.99: 99 * time.Millisecond,
+		1.00: 100 * time.Millisecond,
+		0.00: 1 * time.Millisecond,
+	} {
+		if got := percentile(samples, quantile); got != want {
+			t.Errorf("percentile(%v) = %s, want %s", quantile, got, want)
+		}
+	}
+	if got := percentile(nil, 0.5); got != 0 {
+		t.Errorf("expected 0 without samples, got %s", got)
+	}
+	if got := percentile([]time.Duration{time.Second}, 0.99); got != time.Second {
+		t.Errorf("expected the only sample, got %s", got)
+	}
+}
+
+func TestMergeLatencies(t *testing.T) {
+	first := NewLatencyRecorder()
+	first.Record(10*time.Millisecond, false)
+	first.Record(0, true)
+	second := NewLatencyRecorder()
+	second.Record(30*time.Millisecond, false)
+	second.Record(20*time.Millisecond, false)
+
+	summary := MergeLatencies(first, second)
+	if summary.Count != 4 || summary.Errors != 1 {
+		t.Errorf("expected 4 requests with 1 error, got %d with %d", summary.Count, summary.Errors)
+	}
+	if summary.ErrorRate != 0.25 {
+		t.Errorf("expected a 25%% error rate, got %v", summary.ErrorRate)
+	}
+	if summary.Min != 10*time.Millisecond || summary.Max != 30*time.Millisecond || summary.P50 != 20*time.Millisecond {
+		t.Errorf("unexpected latencies %+v", summary)
+	}
+}
@@ -0,0 +1,102 @@
+package util
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const ConnectError = "connect-error"
+
+// RetryPolicy decides which attempts are retried and how long to wait before
+// each retry, a MaxBackoff of zero leaves the delay unbounded
+type RetryPolicy struct {
+	Retries       uint
+	Backoff       time.Duration
+	MaxBackoff    time.Duration
+	StatusCodes   map[int]bool
+	ConnectErrors bool
+}
+
+// NewRetryPolicy builds a policy from a list of conditions, each condition is
+// either a HTTP status code or ConnectError
+func NewRetryPolicy(retries uint, backoff time.Duration, maxBackoff time.Duration, retryOn []string) (*RetryPolicy, error) {
+	policy := &RetryPolicy{
+		Retries:     retries,
+		Backoff:     backoff,
+		MaxBackoff:  maxBackoff,
+		StatusCodes: map[int]bool{},
+	}
+	for _, condition := range retryOn {
+		condition = strings.TrimSpace(condition)
+		if condition == ConnectError {
+			policy.ConnectErrors = true
+			continue
+		}
+		statusCode, err := strconv.Atoi(condition)
+		if err != nil || statusCode < 100 || statusCode > 599 {
+			return nil, fmt.Errorf("Unknown retry condition '%s', expected a HTTP status code or '%s'", condition, ConnectError)
+		}
+		policy.StatusCodes[statusCode] = true
+	}
+	return policy, nil
+}
+
+func (policy *RetryPolicy) MaxAttempts() uint {
+	return policy.Retries + 1
+}
+
+// ShouldRetry reports whether another attempt should be made after the given
+// attempt (counting from 1), a status code of 0 means no response was received
+func (policy *RetryPolicy) ShouldRetry(attempt uint, statusCode int) bool {
+	if attempt >= policy.MaxAttempts() {
+		return false
+	}
+	if statusCode == 0 {
+		return policy.ConnectErrors
+	}
+	return policy.StatusCodes[statusCode]
+}
+
+// Delay is how long to wait before the attempt following the given attempt,
+// a Retry-After header value takes precedence over the exponential backoff.
+// Both are capped at MaxBackoff so a server cannot stall the run
+func (policy *RetryPolicy) Delay(attempt uint, retryAfter string) time.Duration {
+	limit := time.Duration(math.MaxInt64)
+	if policy.MaxBackoff > 0 {
+		limit = policy.MaxBackoff
+	}
+	if delay, ok := parseRetryAfter(retryAfter); ok {
+		return min(delay, limit)
+	}
+	if policy.Backoff <= 0 {
+		return 0
+	}
+	backoff := limit
+	// Checked before shifting so large backoffs cannot overflow
+	if shift := min(attempt-1, 16); policy.Backoff <= limit>>shift {
+		backoff = policy.Backoff << shift
+	}
+	// Equal jitter, so we always wait at least half of the backoff
+	return backoff/2 + rand.N(backoff/2+1)
+}
+
+func parseRetryAfter(retryAfter string) (time.Duration, bool) {
+	if retryAfter == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.ParseInt(retryAfter, 10, 64); err == nil && seconds >= 0 {
+		if seconds > int64(math.MaxInt64/time.Second) {
+			return time.Duration(math.MaxInt64), true
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	if retryTime, err := http.ParseTime(retryAfter); err == nil {
+		return max(time.Until(retryTime), 0), true
+	}
+	return 0, false
+}
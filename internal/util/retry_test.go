@@ -0,0 +1,117 @@
+package util
+
+import (
+	"math"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestNewRetryPolicy(t *testing.T) {
+	policy, err := NewRetryPolicy(2, time.Second, 0, []string{"503", " 504", ConnectError})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !policy.StatusCodes[503] || !policy.StatusCodes[504] || !policy.ConnectErrors {
+		t.Errorf("expected 503, 504 and connect errors to be retried, got %+v", policy)
+	}
+	for _, condition := range []string{"99", "600", "timeout"} {
+		if _, err := NewRetryPolicy(1, time.Second, 0, []string{condition}); err == nil {
+			t.Errorf("expected '%s' to be rejected", condition)
+		}
+	}
+}
+
+func TestShouldRetry(t *testing.T) {
+	policy, err := NewRetryPolicy(2, time.Second, 0, []string{"503", ConnectError})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		attempt    uint
+		statusCode int
+		want       bool
+	}{
+		{1, 503, true},
+		{2, 0, true},
+		{3, 503, false},
+		{1, 500, false},
+		{1, 200, false},
+	}
+	for _, test := range tests {
+		if got := policy.ShouldRetry(test.attempt, test.statusCode); got != test.want {
+			t.Errorf("ShouldRetry(%d, %d) = %t, want %t", test.attempt, test.statusCode, got, test.want)
+		}
+	}
+
+	withoutConnect, err := NewRetryPolicy(2, time.Second, 0, []string{"503"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if withoutConnect.ShouldRetry(1, 0) {
+		t.Error("expected connect errors not to be retried without connect-error")
+	}
+}
+
+func TestDelayBackoff(t *testing.T) {
+	policy := &RetryPolicy{Backoff: 100 * time.Millisecond}
+	for attempt, backoff := range map[uint]time.Duration{1: 100 * time.Millisecond, 3: 400 * time.Millisecond} {
+		for range 20 {
+			delay := policy.Delay(attempt, "")
+			if delay < backoff/2 || delay > backoff {
+				t.Fatalf("Delay(%d) = %s, want between %s and %s", attempt, delay, backoff/2, backoff)
+			}
+		}
+	}
+	if delay := (&RetryPolicy{}).Delay(1, ""); delay != 0 {
+		t.Errorf("expected no delay without a backoff, got %s", delay)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	if delay, ok := parseRetryAfter("3"); !ok || delay != 3*time.Second {
+		t.Errorf("parseRetryAfter(\"3\") = %s, %t", delay, ok)
+	}
+	for _, value := range []string{"", "-1", "soon"} {
+		if _, ok := parseRetryAfter(value); ok {
+			t.Errorf("expected '%s' to be ignored", value)
+		}
+	}
+
+	later := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+	if delay, ok := parseRetryAfter(later); !ok || delay < 59*time.Minute || delay > time.Hour {
+		t.Errorf("parseRetryAfter(%q) = %s, %t", later, delay, ok)
+	}
+	earlier := time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)
+	if delay, ok := parseRetryAfter(earlier); !ok || delay != 0 {
+		t.Errorf("expected a date in the past to retry straight away, got %s, %t", delay, ok)
+	}
+
+	policy := &RetryPolicy{Backoff: time.Hour}
+	if delay := policy.Delay(1, "2"); delay != 2*time.Second {
+		t.Errorf("expected Retry-After to take precedence, got %s", delay)
+	}
+}
+
+func TestDelayIsCapped(t *testing.T) {
+	policy := &RetryPolicy{Backoff: time.Second, MaxBackoff: 30 * time.Second}
+	if delay := policy.Delay(1, "86400"); delay != 30*time.Second {
+		t.Errorf("expected Retry-After to be capped at 30s, got %s", delay)
+	}
+	if delay := policy.Delay(1, "99999999999999999"); delay != 30*time.Second {
+		t.Errorf("expected a huge Retry-After to be capped at 30s, got %s", delay)
+	}
+	for _, attempt := range []uint{6, 20, 1000} {
+		if delay := policy.Delay(attempt, ""); delay < 15*time.Second || delay > 30*time.Second {
+			t.Errorf("Delay(%d) = %s, want between 15s and 30s", attempt, delay)
+		}
+	}
+
+	// Without a cap a large backoff must not overflow into a negative delay
+	huge := &RetryPolicy{Backoff: time.Duration(math.MaxInt64 / 2)}
+	for _, attempt := range []uint{1, 2, 17} {
+		if delay := huge.Delay(attempt, ""); delay < huge.Backoff/2 {
+			t.Errorf("Delay(%d) = %s, want at least %s", attempt, delay, huge.Backoff/2)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package util
+
+import (
+	"io"
+	"net"
+	"sync"
+)
+
+func closeWrite(conn net.Conn) {
+	if halfCloser, ok := conn.(interface{ CloseWrite() error }); ok {
+		halfCloser.CloseWrite()
+	}
+}
+
+// Splice copies between a client and a remote connection in both directions
+// until both sides are done, each side is half closed once the other stops
+// sending. The client is read through clientReader so anything already
+// buffered (e.g. after a handshake) is not lost
+func Splice(client net.Conn, clientReader io.Reader, remote net.Conn) (sent int64, received int64) {
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		sent, _ = io.Copy(remote, clientReader)
+		closeWrite(remote)
+	}()
+	received, _ = io.Copy(client, remote)
+	closeWrite(client)
+	wg.Wait()
+	return sent, received
+}
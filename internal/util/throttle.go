@@ -0,0 +1,88 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Throttle bounds how many requests can be in flight at once and how quickly
+// new requests can be started, a zero value for either means unbounded
+type Throttle struct {
+	slots   chan struct{}
+	limiter *rate.Limiter
+}
+
+func NewThrottle(parallelism uint, requestsPerSecond float64) *Throttle {
+	throttle := &Throttle{}
+	if parallelism > 0 {
+		throttle.slots = make(chan struct{}, parallelism)
+	}
+	if requestsPerSecond > 0 {
+		throttle.limiter = rate.NewLimiter(rate.Limit(requestsPerSecond), 1)
+	}
+	return throttle
+}
+
+// Limited reports whether callers of Acquire may have to wait
+func (throttle *Throttle) Limited() bool {
+	return throttle.slots != nil || throttle.limiter != nil
+}
+
+// Acquire blocks until a slot is free and the rate limit allows another
+// request, every successful Acquire must be paired with a Release
+func (throttle *Throttle) Acquire(ctx context.Context) error {
+	if throttle.slots != nil {
+		select {
+		case throttle.slots <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if throttle.limiter != nil {
+		if err := throttle.limiter.Wait(ctx); err != nil {
+			throttle.Release()
+			return err
+		}
+	}
+	return nil
+}
+
+func (throttle *Throttle) Release() {
+	if throttle.slots != nil {
+		<-throttle.slots
+	}
+}
+
+// ParseRate parses a rate in the form R/s, R/m or R/h (or a bare R meaning
+// per second) and returns it as a number of requests per second
+func ParseRate(value string) (float64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	amount, unit, hasUnit := strings.Cut(value, "/")
+	interval := time.Second
+	if hasUnit {
+		switch unit {
+		case "s":
+			interval = time.Second
+		case "m":
+			interval = time.Minute
+		case "h":
+			interval = time.Hour
+		default:
+			return 0, fmt.Errorf("Unknown rate unit '%s', expected one of s/m/h", unit)
+		}
+	}
+	// ParseFloat accepts NaN and Inf, neither of which is a usable rate
+	count, err := strconv.ParseFloat(amount, 64)
+	if err != nil || !(count > 0) || math.IsInf(count, 0) {
+		return 0, fmt.Errorf("Invalid rate '%s', expected a positive number of requests", value)
+	}
+	return count / interval.Seconds(), nil
+}
@@ -0,0 +1,31 @@
+package util
+
+import "testing"
+
+func TestParseRate(t *testing.T) {
+	tests := map[string]float64{
+		"":      0,
+		"5":     5,
+		"1e-3":  0.001,
+		"5/s":   5,
+		"120/m": 2,
+		"0.5/s": 0.5,
+		"36/h":  0.01,
+	}
+	for value, want := range tests {
+		got, err := ParseRate(value)
+		if err != nil {
+			t.Errorf("ParseRate(%q) failed: %v", value, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("ParseRate(%q) = %v, want %v", value, got, want)
+		}
+	}
+
+	for _, value := range []string{"5/d", "fast", "-1/s", "/s", "0", "0/m", "NaN", "nan/s", "Inf", "+Inf/h", "-Inf", "1e400"} {
+		if _, err := ParseRate(value); err == nil {
+			t.Errorf("expected '%s' to be rejected", value)
+		}
+	}
+}
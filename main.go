@@ -2,14 +2,20 @@ package main
 
 import (
 	"context"
+	"errors"
 	"os"
 
 	"github.com/charmbracelet/fang"
 	"github.com/mini-ninja-64/flotilla/cmd/root"
+	"github.com/mini-ninja-64/flotilla/internal/util"
 )
 
 func main() {
 	if err := fang.Execute(context.Background(), root.Cmd()); err != nil {
+		var exitErr *util.ExitError
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.Code)
+		}
 		os.Exit(1)
 	}
 }